@@ -2,18 +2,34 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	accrualclient "github.com/KirillZiborov/go-loyalty-program/internal/accrualClient"
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
 	"github.com/KirillZiborov/go-loyalty-program/internal/config"
 	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/events"
+	"github.com/KirillZiborov/go-loyalty-program/internal/grpcapi"
 	"github.com/KirillZiborov/go-loyalty-program/internal/gzip"
-	"github.com/KirillZiborov/go-loyalty-program/internal/handlers"
+	"github.com/KirillZiborov/go-loyalty-program/internal/health"
 	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
-	"github.com/go-chi/chi"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+	"github.com/KirillZiborov/go-loyalty-program/internal/openapi"
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
+	"github.com/KirillZiborov/go-loyalty-program/internal/server"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -22,18 +38,57 @@ var (
 
 func main() {
 
-	err := logging.Initialize()
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		cfg, err := config.Load(os.Args[3:])
+		if err != nil {
+			exitOnConfigError(err)
+		}
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Unable to print config: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		logging.Sugar.Errorw("Internal logging error", err)
+		exitOnConfigError(err)
 	}
 
-	cfg := config.NewConfig()
+	err = logging.Initialize(cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatalf("Internal logging error: %v", err)
+	}
+	defer logging.Sugar.Sync()
+
+	switch cfg.TraceExporter {
+	case "stdout":
+		tracing.SetExporter(tracing.NewStdoutExporter())
+	case "file":
+		exporter, err := tracing.NewFileExporter(cfg.TraceFile)
+		if err != nil {
+			logging.Sugar.Fatalw("Unable to open trace file", "file", cfg.TraceFile, "error", err)
+		}
+		tracing.SetExporter(exporter)
+	}
+	defer tracing.Shutdown()
+
+	auth.Configure(cfg.JWTSecret, cfg.TokenTTL)
 
 	if cfg.DBPath != "" {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DBConnectTimeout)
 		defer cancel()
 
-		db, err = pgxpool.New(ctx, cfg.DBPath)
+		poolConfig, err := pgxpool.ParseConfig(cfg.DBPath)
+		if err != nil {
+			logging.Sugar.Fatalw("Invalid database address", "error", err)
+		}
+		poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+		poolConfig.MaxConns = int32(cfg.DBMaxConns)
+		poolConfig.MinConns = int32(cfg.DBMinConns)
+		poolConfig.MaxConnLifetime = cfg.DBMaxConnLifetime
+		poolConfig.MaxConnIdleTime = cfg.DBMaxConnIdleTime
+
+		db, err = pgxpool.NewWithConfig(ctx, poolConfig)
 		if err != nil {
 			logging.Sugar.Fatalw("Unable to connect to database", "error", err)
 			os.Exit(1)
@@ -44,6 +99,11 @@ func main() {
 			logging.Sugar.Fatalw("Failed to create users table", "error", err)
 			os.Exit(1)
 		}
+		err = database.CreateMerchantsTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create merchants table", "error", err)
+			os.Exit(1)
+		}
 		err = database.CreateOrdersTable(ctx, db)
 		if err != nil {
 			logging.Sugar.Fatalw("Failed to create orders table", "error", err)
@@ -54,33 +114,177 @@ func main() {
 			logging.Sugar.Fatalw("Failed to create withdrawals table", "error", err)
 			os.Exit(1)
 		}
+		err = database.CreateTransfersTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create transfers table", "error", err)
+			os.Exit(1)
+		}
+		err = database.CreateReferralRewardsTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create referral rewards table", "error", err)
+			os.Exit(1)
+		}
+		err = database.CreateCampaignsTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create campaigns tables", "error", err)
+			os.Exit(1)
+		}
+		err = database.CreateQuotesTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create quotes table", "error", err)
+			os.Exit(1)
+		}
+		err = database.CreateEventsTable(ctx, db)
+		if err != nil {
+			logging.Sugar.Fatalw("Failed to create events table", "error", err)
+			os.Exit(1)
+		}
 		defer db.Close()
 	} else {
 		logging.Sugar.Fatalw("No database address")
 	}
 
-	go accrualclient.StartAccrual(cfg, context.Background(), db)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	r := chi.NewRouter()
+	reloader := config.NewReloader(cfg, os.Args[1:])
+	reloader.OnReload(func(cfg *config.Config) {
+		logging.SetLevel(cfg.LogLevel)
+	})
+	go reloader.Watch(ctx)
 
-	r.Use(logging.LoggingMiddleware())
+	go accrualclient.StartAccrual(reloader, ctx, db)
 
-	r.Post("/api/user/register", gzip.Middleware(handlers.RegisterUser(db)))
-	r.Post("/api/user/login", gzip.Middleware(handlers.LoginUser(db)))
-	r.Post("/api/user/orders", gzip.Middleware(handlers.SubmitOrder(db)))
-	r.Post("/api/user/balance/withdraw", gzip.Middleware(handlers.Withdraw(db)))
+	checker := health.NewChecker(db)
+	numbers, err := ordernumber.ParseProfiles(cfg.OrderNumberProfiles)
+	if err != nil {
+		logging.Sugar.Fatalw("Invalid order number profiles", "error", err)
+	}
+	svc := service.NewLoyaltyService(service.PostgresStore(db), service.Limits{
+		TransferDaily:         float32(cfg.TransferDailyLimit),
+		OrderBatch:            cfg.OrderBatchLimit,
+		RejectSameIPReferrals: cfg.ReferralRejectSameIP,
+		SignupIPRetention:     cfg.SignupIPRetention,
+		Redemption: service.Redemption{
+			Min:          float32(cfg.RedemptionMin),
+			Max:          float32(cfg.RedemptionMax),
+			MaxPercent:   float32(cfg.RedemptionMaxPercent),
+			OncePerOrder: cfg.RedemptionOncePerOrder,
+			Hold:         cfg.WithdrawalHoldTTL,
+			QuoteTTL:     cfg.QuoteTTL,
+		},
+	}, numbers)
+
+	go svc.ReleaseExpired(ctx, cfg.WithdrawalExpiryInterval)
+	go svc.PruneSignupIPs(ctx)
+
+	hub := events.NewHub(db)
+	go hub.Run(ctx)
+	go events.Prune(ctx, db, cfg.EventsRetention)
 
-	r.Get("/api/user/orders", gzip.Middleware(handlers.GetOrders(db)))
-	r.Get("/api/user/balance", gzip.Middleware(handlers.GetBalance(db)))
-	r.Get("/api/user/withdrawals", gzip.Middleware(handlers.GetWithdrawals(db)))
+	gzip.MaxDecompressedSize = cfg.MaxDecompressedBodySize
+	openapi.ValidateResponses = cfg.OpenAPIValidateResponses
+	metrics.RegisterPool(db)
+
+	r := newRouter(cfg, svc, hub, checker)
+	if err := openapi.Check(r); err != nil {
+		logging.Sugar.Fatalw("API document is out of date", "error", err)
+	}
+
+	timeouts := server.Timeouts{
+		ReadHeader: cfg.ReadHeaderTimeout,
+		Read:       cfg.ReadTimeout,
+		Write:      cfg.WriteTimeout,
+		Idle:       cfg.IdleTimeout,
+	}
+	srv := server.New(cfg.Address, r, timeouts)
+	var redirect *http.Server
 
 	logging.Sugar.Infow(
 		"Starting server at",
 		"addr", cfg.Address,
+		"tls", cfg.TLSEnabled(),
 	)
 
-	err = http.ListenAndServe(cfg.Address, r)
-	if err != nil {
+	serverErr := make(chan error, 3)
+	if cfg.TLSEnabled() {
+		certs, err := server.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logging.Sugar.Fatalw("Unable to load TLS certificate", "error", err)
+		}
+		go certs.Watch(ctx, cfg.TLSReloadInterval)
+
+		srv.TLSConfig, err = server.TLSConfig(certs, cfg.TLSClientCAFile)
+		if err != nil {
+			logging.Sugar.Fatalw("Invalid TLS configuration", "error", err)
+		}
+		go func() {
+			serverErr <- srv.ListenAndServeTLS("", "")
+		}()
+
+		if cfg.RedirectAddress != "" {
+			redirect = server.New(cfg.RedirectAddress, server.RedirectHandler(cfg.Address), timeouts)
+			logging.Sugar.Infow("Redirecting HTTP to HTTPS", "addr", cfg.RedirectAddress)
+			go func() {
+				serverErr <- redirect.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			serverErr <- srv.ListenAndServe()
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			logging.Sugar.Fatalw("Unable to listen for gRPC", "addr", cfg.GRPCAddress, "error", err)
+		}
+		grpcServer = grpcapi.New(svc, cfg.GRPCWatchInterval, srv.TLSConfig)
+		logging.Sugar.Infow("Starting gRPC server at", "addr", cfg.GRPCAddress)
+		go func() {
+			serverErr <- grpcServer.Serve(lis)
+		}()
+	}
+
+	select {
+	case err = <-serverErr:
 		logging.Sugar.Fatalw(err.Error(), "event", "start server")
+	case <-ctx.Done():
+	}
+
+	logging.Sugar.Infow("Shutting down server")
+	// Keep serving while readiness fails so that load balancers stop sending traffic.
+	checker.SetShuttingDown()
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logging.Sugar.Errorw("Graceful shutdown failed", "error", err)
+	}
+}
+
+func exitOnConfigError(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
+	fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+	os.Exit(2)
 }
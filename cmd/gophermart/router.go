@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/config"
+	"github.com/KirillZiborov/go-loyalty-program/internal/events"
+	"github.com/KirillZiborov/go-loyalty-program/internal/gzip"
+	"github.com/KirillZiborov/go-loyalty-program/internal/handlers"
+	"github.com/KirillZiborov/go-loyalty-program/internal/health"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+	"github.com/KirillZiborov/go-loyalty-program/internal/openapi"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/server"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/go-chi/chi"
+)
+
+// orderBodySize limits order uploads, which carry a single order number.
+const orderBodySize = 1 << 10
+
+// newRouter routes the HTTP API to svc. Admin routes are only served with an admin token configured.
+func newRouter(cfg *config.Config, svc *service.LoyaltyService, hub *events.Hub, checker *health.Checker) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(logging.RequestIDMiddleware())
+	r.Use(logging.LoggingMiddleware())
+	r.Use(metrics.Middleware())
+	r.Use(tracing.Middleware())
+	r.Use(server.RecoveryMiddleware())
+	r.NotFound(response.NotFound)
+	r.MethodNotAllowed(response.MethodNotAllowed)
+
+	limit := func(h http.HandlerFunc) http.HandlerFunc {
+		return server.MaxBodySize(cfg.MaxBodySize, h)
+	}
+
+	r.Get("/metrics", metrics.Default.Handler())
+
+	r.Get("/healthz", checker.Liveness())
+	r.Get("/readyz", checker.Readiness())
+	r.Get("/status", checker.Status())
+	r.Get("/openapi.json", openapi.Handler())
+
+	r.Post("/api/user/register", limit(gzip.Middleware(openapi.Validate(handlers.RegisterUser(svc, cfg.ClientIPHeader)))))
+	r.Post("/api/user/login", limit(gzip.Middleware(openapi.Validate(handlers.LoginUser(svc)))))
+	r.Post("/api/user/orders", server.MaxBodySize(orderBodySize, gzip.Middleware(openapi.Validate(handlers.SubmitOrder(svc)))))
+	r.Post("/api/user/orders/batch", limit(gzip.Middleware(openapi.Validate(handlers.SubmitOrders(svc)))))
+	r.Post("/api/user/balance/withdraw", limit(gzip.Middleware(openapi.Validate(handlers.Withdraw(svc)))))
+	r.Post("/api/user/balance/quote", limit(gzip.Middleware(openapi.Validate(handlers.Quote(svc)))))
+	r.Post("/api/user/balance/transfer", limit(gzip.Middleware(openapi.Validate(handlers.Transfer(svc)))))
+	r.Post("/api/user/withdrawals", limit(gzip.Middleware(openapi.Validate(handlers.ReserveWithdrawal(svc)))))
+	r.Post("/api/user/withdrawals/{id}/confirm", openapi.Validate(handlers.ConfirmWithdrawal(svc)))
+	r.Post("/api/user/withdrawals/{id}/cancel", openapi.Validate(handlers.CancelWithdrawal(svc)))
+
+	r.Get("/api/user/orders", gzip.Middleware(openapi.Validate(handlers.GetOrders(svc))))
+	r.Get("/api/user/orders/stream", openapi.Validate(handlers.StreamEvents(svc, hub, cfg.EventsHeartbeatInterval)))
+	r.Get("/api/user/ws", openapi.Validate(handlers.Websocket(hub, cfg.EventsHeartbeatInterval, cfg.AllowedOrigins())))
+	r.Get("/api/user/balance", gzip.Middleware(openapi.Validate(handlers.GetBalance(svc))))
+	r.Get("/api/user/withdrawals", gzip.Middleware(openapi.Validate(handlers.GetWithdrawals(svc))))
+	r.Get("/api/user/transfers", gzip.Middleware(openapi.Validate(handlers.GetTransfers(svc))))
+	r.Get("/api/user/referrals", gzip.Middleware(openapi.Validate(handlers.GetReferrals(svc))))
+	r.Get("/api/user/statement", gzip.Middleware(openapi.Validate(handlers.GetStatement(svc))))
+
+	if cfg.AdminToken != "" {
+		r.Route("/api/admin", func(r chi.Router) {
+			if cfg.TLSClientCAFile != "" {
+				r.Use(server.RequireClientCert())
+			}
+			r.Use(auth.AdminMiddleware(cfg.AdminToken))
+
+			r.Post("/campaigns", limit(gzip.Middleware(handlers.CreateCampaign(svc))))
+			r.Post("/campaigns/{id}/pause", limit(gzip.Middleware(handlers.PauseCampaign(svc))))
+			r.Post("/campaigns/{id}/resume", limit(gzip.Middleware(handlers.ResumeCampaign(svc))))
+			r.Get("/campaigns/{id}/preview", gzip.Middleware(handlers.PreviewCampaign(svc)))
+			r.Post("/merchants", limit(gzip.Middleware(handlers.CreateMerchant(svc))))
+			r.Get("/merchants", gzip.Middleware(handlers.GetMerchants(svc)))
+		})
+	}
+
+	return r
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/config"
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/events"
+	"github.com/KirillZiborov/go-loyalty-program/internal/health"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/openapi"
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service/servicetest"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const contractUserID = 1
+
+var errStore = errors.New("store failed")
+
+// contractCase is a request producing one documented response of an operation.
+type contractCase struct {
+	// target is the request path and query, the operation path if empty.
+	target      string
+	body        string
+	contentType string
+	header      map[string]string
+	anonymous   bool
+	store       servicetest.Store
+}
+
+const validOrder = "12345678903"
+
+var withdrawBody = `{"order": "` + validOrder + `", "sum": 10}`
+
+// contractCases holds the cases keyed by operation id and status. Unauthenticated
+// and oversized requests are generated for operations without their own case.
+var contractCases = map[string]contractCase{
+	"registerUser 200": {body: `{"login": "alice", "password": "secret"}`},
+	"registerUser 400": {body: `{"login": "", "password": "secret"}`},
+	"registerUser 409": {body: `{"login": "alice", "password": "secret"}`, store: servicetest.Store{
+		CreateUserFunc: func(context.Context, *models.User, bool) (int, error) { return 0, database.ErrorDuplicate },
+	}},
+	"registerUser 500": {body: `{"login": "alice", "password": "secret"}`, store: servicetest.Store{
+		CreateUserFunc: func(context.Context, *models.User, bool) (int, error) { return 0, errStore },
+	}},
+
+	"loginUser 200": {body: `{"login": "alice", "password": "secret"}`, store: servicetest.Store{
+		GetUserByLoginFunc: func(context.Context, string) (*models.User, error) {
+			return &models.User{ID: contractUserID, Login: "alice", Password: secretHash}, nil
+		},
+	}},
+	"loginUser 400": {body: `{"login": "alice"}`},
+	"loginUser 401": {body: `{"login": "alice", "password": "secret"}`},
+	"loginUser 500": {body: `{"login": "alice", "password": "secret"}`, store: servicetest.Store{
+		GetUserByLoginFunc: func(context.Context, string) (*models.User, error) { return nil, errStore },
+	}},
+
+	"submitOrder 200": {body: validOrder, contentType: "text/plain", store: servicetest.Store{
+		OrderExistsFunc: func(context.Context, int, string) (bool, int, error) { return true, contractUserID, nil },
+	}},
+	"submitOrder 202": {body: validOrder, contentType: "text/plain"},
+	"submitOrder 400": {target: "/api/user/orders?merchant=nope", body: validOrder, contentType: "text/plain", store: servicetest.Store{
+		GetMerchantByCodeFunc: func(context.Context, string) (*models.Merchant, error) { return nil, database.ErrorMerchantNotFound },
+	}},
+	"submitOrder 409": {body: validOrder, contentType: "text/plain", store: servicetest.Store{
+		OrderExistsFunc: func(context.Context, int, string) (bool, int, error) { return true, contractUserID + 1, nil },
+	}},
+	"submitOrder 422": {body: "12345678901", contentType: "text/plain"},
+	"submitOrder 500": {body: validOrder, contentType: "text/plain", store: servicetest.Store{
+		AddOrderFunc: func(context.Context, int, int, string) error { return errStore },
+	}},
+
+	"submitOrders 200": {body: `["` + validOrder + `", "12345678901"]`},
+	"submitOrders 400": {body: `[]`},
+	"submitOrders 500": {body: `["` + validOrder + `"]`, store: servicetest.Store{
+		AddOrdersFunc: func(context.Context, int, int, []string) (map[string]bool, map[string]int, error) {
+			return nil, nil, errStore
+		},
+	}},
+
+	"getOrders 200": {store: servicetest.Store{
+		GetOrdersByUserIDFunc: func(context.Context, int, *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+			accrual := float32(500)
+			return []models.Order{
+				{OrderNumber: validOrder, Status: models.OrderStatusProcessed, Accrual: &accrual, UploadedAt: time.Now(),
+					Merchant: models.MerchantBrand{Code: "default", Name: "Gophermart"}},
+				{OrderNumber: "2377225624", Status: models.OrderStatusNew, UploadedAt: time.Now(),
+					Merchant: models.MerchantBrand{Code: "default", Name: "Gophermart"}},
+			}, nil, nil
+		},
+	}},
+	"getOrders 204": {},
+	"getOrders 400": {target: "/api/user/orders?limit=0"},
+	"getOrders 500": {store: servicetest.Store{
+		GetOrdersByUserIDFunc: func(context.Context, int, *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+			return nil, nil, errStore
+		},
+	}},
+
+	"streamEvents 200": {},
+	"streamEvents 400": {header: map[string]string{"Last-Event-ID": "x"}},
+	"streamEvents 500": {header: map[string]string{"Last-Event-ID": "1"}, store: servicetest.Store{
+		GetEventsAfterFunc: func(context.Context, int, int64, int) ([]models.Event, error) { return nil, errStore },
+	}},
+
+	"openWebSocket 101": {},
+	"openWebSocket 400": {},
+	"openWebSocket 403": {},
+
+	"getBalance 200": {store: servicetest.Store{
+		GetUserBalanceFunc: func(context.Context, int) (*models.Balance, error) {
+			return &models.Balance{Current: 500.5, Withdrawn: 42}, nil
+		},
+	}},
+	"getBalance 500": {store: servicetest.Store{
+		GetUserBalanceFunc: func(context.Context, int) (*models.Balance, error) { return nil, errStore },
+	}},
+
+	"withdraw 200": {body: withdrawBody},
+	"withdraw 400": {body: `{"order": "` + validOrder + `"}`},
+	"withdraw 402": {body: withdrawBody, store: withdrawStore(database.ErrorInsufficientFunds)},
+	"withdraw 404": {body: withdrawBody, store: withdrawStore(database.ErrorQuoteNotFound)},
+	"withdraw 409": {body: withdrawBody, store: withdrawStore(database.ErrorOrderRedeemed)},
+	"withdraw 422": {body: `{"order": "12345678901", "sum": 10}`},
+	"withdraw 500": {body: withdrawBody, store: withdrawStore(errStore)},
+
+	"quote 200": {body: `{"order_value": 1000}`},
+	"quote 400": {body: `{"order_value": 0}`},
+	"quote 500": {body: `{"order_value": 1000}`, store: servicetest.Store{
+		CreateQuoteFunc: func(context.Context, int, int, float32, float32, float32, time.Duration) (*models.Quote, error) {
+			return nil, errStore
+		},
+	}},
+
+	"transfer 200": {body: `{"to": "bob", "sum": 10}`},
+	"transfer 400": {body: `{"to": "alice", "sum": 10}`, store: transferStore(database.ErrorSelfTransfer)},
+	"transfer 402": {body: `{"to": "bob", "sum": 10}`, store: transferStore(database.ErrorInsufficientFunds)},
+	"transfer 404": {body: `{"to": "bob", "sum": 10}`, store: transferStore(database.ErrorUserNotFound)},
+	"transfer 422": {body: `{"to": "bob", "sum": 10}`, store: transferStore(database.ErrorTransferLimit)},
+	"transfer 500": {body: `{"to": "bob", "sum": 10}`, store: transferStore(errStore)},
+
+	"getWithdrawals 200": {store: servicetest.Store{
+		GetWithdrawalsFunc: func(context.Context, int, *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+			value := float32(100)
+			expiresAt := time.Now().Add(time.Minute)
+			return []models.Withdrawal{
+				{ID: 2, OrderNumber: validOrder, Sum: 10, OrderValue: &value, Status: models.WithdrawalStatusPending,
+					ExpiresAt: &expiresAt, ProcessedAt: time.Now()},
+				{ID: 1, OrderNumber: "2377225624", Sum: 5, Status: models.WithdrawalStatusConfirmed, ProcessedAt: time.Now()},
+			}, nil, nil
+		},
+	}},
+	"getWithdrawals 204": {},
+	"getWithdrawals 400": {target: "/api/user/withdrawals?from=yesterday"},
+	"getWithdrawals 500": {store: servicetest.Store{
+		GetWithdrawalsFunc: func(context.Context, int, *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+			return nil, nil, errStore
+		},
+	}},
+
+	"reserveWithdrawal 201": {body: withdrawBody},
+	"reserveWithdrawal 400": {body: `{"order": "` + validOrder + `", "sum": -1}`},
+	"reserveWithdrawal 402": {body: withdrawBody, store: reserveStore(database.ErrorInsufficientFunds)},
+	"reserveWithdrawal 404": {body: withdrawBody, store: reserveStore(database.ErrorQuoteNotFound)},
+	"reserveWithdrawal 409": {body: withdrawBody, store: reserveStore(database.ErrorQuoteExpired)},
+	"reserveWithdrawal 422": {body: `{"order": "` + validOrder + `", "sum": 10, "quote": "q"}`, store: reserveStore(database.ErrorQuoteExceeded)},
+	"reserveWithdrawal 500": {body: withdrawBody, store: reserveStore(errStore)},
+
+	"confirmWithdrawal 200": {target: "/api/user/withdrawals/1/confirm"},
+	"confirmWithdrawal 400": {target: "/api/user/withdrawals/x/confirm"},
+	"confirmWithdrawal 404": {target: "/api/user/withdrawals/1/confirm", store: servicetest.Store{ConfirmWithdrawalFunc: resolveFails(database.ErrorWithdrawalNotFound)}},
+	"confirmWithdrawal 409": {target: "/api/user/withdrawals/1/confirm", store: servicetest.Store{ConfirmWithdrawalFunc: resolveFails(database.ErrorWithdrawalExpired)}},
+	"confirmWithdrawal 500": {target: "/api/user/withdrawals/1/confirm", store: servicetest.Store{ConfirmWithdrawalFunc: resolveFails(errStore)}},
+	"confirmWithdrawal 401": {target: "/api/user/withdrawals/1/confirm", anonymous: true},
+
+	"cancelWithdrawal 200": {target: "/api/user/withdrawals/1/cancel"},
+	"cancelWithdrawal 400": {target: "/api/user/withdrawals/x/cancel"},
+	"cancelWithdrawal 404": {target: "/api/user/withdrawals/1/cancel", store: servicetest.Store{CancelWithdrawalFunc: resolveFails(database.ErrorWithdrawalNotFound)}},
+	"cancelWithdrawal 409": {target: "/api/user/withdrawals/1/cancel", store: servicetest.Store{CancelWithdrawalFunc: resolveFails(database.ErrorWithdrawalNotPending)}},
+	"cancelWithdrawal 500": {target: "/api/user/withdrawals/1/cancel", store: servicetest.Store{CancelWithdrawalFunc: resolveFails(errStore)}},
+	"cancelWithdrawal 401": {target: "/api/user/withdrawals/1/cancel", anonymous: true},
+
+	"getTransfers 200": {store: servicetest.Store{
+		GetUserTransfersFunc: func(context.Context, int) ([]models.Transfer, error) {
+			return []models.Transfer{
+				{Direction: "IN", Counterparty: "bob", Sum: 5, ProcessedAt: time.Now()},
+				{Direction: "OUT", Counterparty: "carol", Sum: 3, ProcessedAt: time.Now()},
+			}, nil
+		},
+	}},
+	"getTransfers 204": {},
+	"getTransfers 500": {store: servicetest.Store{
+		GetUserTransfersFunc: func(context.Context, int) ([]models.Transfer, error) { return nil, errStore },
+	}},
+
+	"getReferrals 200": {store: servicetest.Store{
+		GetUserReferralsFunc: func(context.Context, int) (*models.ReferralsResponse, error) {
+			return &models.ReferralsResponse{Code: "ABCD1234", TotalEarned: 50,
+				Invited: []models.Referral{{Login: "bob", RegisteredAt: time.Now()}}}, nil
+		},
+	}},
+	"getReferrals 500": {store: servicetest.Store{
+		GetUserReferralsFunc: func(context.Context, int) (*models.ReferralsResponse, error) { return nil, errStore },
+	}},
+
+	"getStatement 200": {target: "/api/user/statement?format=jsonl"},
+	"getStatement 400": {target: "/api/user/statement?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z"},
+	"getStatement 500": {store: servicetest.Store{
+		GetBalanceAtFunc: func(context.Context, int, time.Time) (float32, error) { return 0, errStore },
+	}},
+}
+
+// secretHash is the password hash of the user logging in with "secret".
+var secretHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+func withdrawStore(err error) servicetest.Store {
+	return servicetest.Store{
+		WithdrawBalanceFunc: func(context.Context, int, int, float32, string, *float32, bool, string) error { return err },
+	}
+}
+
+func reserveStore(err error) servicetest.Store {
+	return servicetest.Store{
+		ReserveWithdrawalFunc: func(context.Context, int, int, float32, string, *float32, bool, time.Duration, string) (*models.Withdrawal, error) {
+			return nil, err
+		},
+	}
+}
+
+func transferStore(err error) servicetest.Store {
+	return servicetest.Store{
+		TransferBalanceFunc: func(context.Context, int, string, float32, float32) error { return err },
+	}
+}
+
+func resolveFails(err error) func(context.Context, int, int) (*models.Withdrawal, error) {
+	return func(context.Context, int, int) (*models.Withdrawal, error) { return nil, err }
+}
+
+// TestContract sends a request for every documented response of every operation
+// to the real router and checks the status and body against the API document.
+func TestContract(t *testing.T) {
+	cfg, err := config.Load([]string{"-d", "postgres://contract", "-r", "http://accrual", "-jwt-secret", "contract-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxBodySize = 1 << 10
+	auth.Configure(cfg.JWTSecret, time.Hour)
+	token, err := auth.BuildJWTString(contractUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers, err := ordernumber.NewProfiles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := openapi.Check(newRouter(cfg, nil, events.NewHub(nil), health.NewChecker(nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	used := make(map[string]bool)
+	for path, ops := range openapi.Spec().Paths {
+		for _, op := range ops {
+			for status := range op.Responses {
+				name := op.OperationID + " " + status
+				tc, ok := contractCases[name]
+				used[name] = ok
+				if !ok {
+					if tc, ok = generatedCase(op, status); !ok {
+						t.Errorf("%s: no contract case", name)
+						continue
+					}
+				}
+				if tc.target == "" {
+					tc.target = path
+				}
+
+				t.Run(name, func(t *testing.T) {
+					store := tc.store
+					svc := service.NewLoyaltyService(&store, service.Limits{OrderBatch: 10, TransferDaily: 100,
+						Redemption: service.Redemption{MaxPercent: 100, Hold: time.Minute, QuoteTTL: time.Minute}}, numbers)
+					srv := httptest.NewServer(newRouter(cfg, svc, events.NewHub(nil), health.NewChecker(nil)))
+					defer srv.Close()
+
+					code, _ := strconv.Atoi(status)
+					got, header, body := contractRequest(t, srv, op, code, tc, token)
+					if got != code {
+						t.Fatalf("status = %d, want %d: %s", got, code, body)
+					}
+					if err := openapi.CheckResponse(op.Method, path, got, header, body); err != nil {
+						t.Fatalf("%v: %s", err, body)
+					}
+				})
+			}
+		}
+	}
+	for name := range contractCases {
+		if _, ok := used[name]; !ok {
+			t.Errorf("%s: case for an undocumented response", name)
+		}
+	}
+}
+
+// generatedCase returns the unauthenticated and oversized request cases, which every operation handles alike.
+// Unauthenticated requests are the successful request without the cookie, the body is checked first.
+func generatedCase(op openapi.Operation, status string) (contractCase, bool) {
+	switch {
+	case status == "401" && len(op.Security) > 0:
+		for _, success := range []string{"101", "200", "201", "202"} {
+			if tc, ok := contractCases[op.OperationID+" "+success]; ok {
+				tc.anonymous = true
+				tc.store = servicetest.Store{}
+				return tc, true
+			}
+		}
+	case status == "413" && op.RequestBody != nil:
+		return contractCase{body: `{"login": "` + strings.Repeat("a", 2<<10) + `"}`}, true
+	}
+	return contractCase{}, false
+}
+
+// contractRequest sends the request of tc and returns the response. Event streams
+// are only read up to their headers, WebSocket handshakes are made with a real client.
+func contractRequest(t *testing.T, srv *httptest.Server, op openapi.Operation, status int, tc contractCase, token string) (int, http.Header, []byte) {
+	t.Helper()
+	header := http.Header{}
+	if !tc.anonymous {
+		header.Set("Cookie", "cookie="+token)
+	}
+	for k, v := range tc.header {
+		header.Set(k, v)
+	}
+
+	if op.OperationID == "openWebSocket" && status != http.StatusUnauthorized {
+		if status == http.StatusForbidden {
+			header.Set("Origin", "https://elsewhere.example")
+		}
+		if status == http.StatusSwitchingProtocols || status == http.StatusForbidden {
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+tc.target, header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("WebSocket handshake: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, resp.Header, body
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, op.Method, srv.URL+tc.target, strings.NewReader(tc.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	if tc.body != "" {
+		contentType := tc.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") == "text/event-stream" {
+		return resp.StatusCode, resp.Header, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(fmt.Errorf("reading the response: %w", err))
+	}
+	if slices.Contains([]int{http.StatusNoContent, http.StatusAccepted}, resp.StatusCode) && len(body) > 0 {
+		t.Errorf("status %d with body %q", resp.StatusCode, body)
+	}
+	return resp.StatusCode, resp.Header, body
+}
+
+func TestRegisterClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipHeader string
+		header   map[string]string
+		want     string
+	}{
+		{name: "connection address", header: map[string]string{"X-Forwarded-For": "203.0.113.9"}, want: "192.0.2.1"},
+		{name: "trusted header", ipHeader: "X-Real-IP", header: map[string]string{"X-Real-IP": "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "address appended by the proxy", ipHeader: "X-Forwarded-For", header: map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "missing header", ipHeader: "X-Real-IP", want: ""},
+		{name: "invalid header", ipHeader: "X-Real-IP", header: map[string]string{"X-Real-IP": "unknown"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load([]string{"-d", "postgres://register", "-r", "http://accrual", "-client-ip-header", tt.ipHeader})
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth.Configure("register-secret", time.Hour)
+			var got string
+			store := &servicetest.Store{
+				CreateUserFunc: func(_ context.Context, user *models.User, _ bool) (int, error) {
+					got = user.SignupIP
+					return 1, nil
+				},
+			}
+			svc := service.NewLoyaltyService(store, service.Limits{RejectSameIPReferrals: true}, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login": "alice", "password": "secret"}`))
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newRouter(cfg, svc, events.NewHub(nil), health.NewChecker(nil)).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if got != tt.want {
+				t.Errorf("signup address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPendingWithdrawals(t *testing.T) {
+	cfg, err := config.Load([]string{"-d", "postgres://withdrawals", "-r", "http://accrual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Configure("withdrawals-secret", time.Hour)
+	token, err := auth.BuildJWTString(contractUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	store := &servicetest.Store{
+		GetWithdrawalsFunc: func(_ context.Context, _ int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+			statuses = params.Statuses
+			expiresAt := time.Now().Add(time.Minute)
+			return []models.Withdrawal{{ID: 1, OrderNumber: validOrder, Sum: 10, Status: models.WithdrawalStatusPending,
+				ExpiresAt: &expiresAt, ProcessedAt: time.Now()}}, nil, nil
+		},
+	}
+	svc := service.NewLoyaltyService(store, service.Limits{}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?status=pending", nil)
+	req.Header.Set("Cookie", "cookie="+token)
+	rec := httptest.NewRecorder()
+	newRouter(cfg, svc, events.NewHub(nil), health.NewChecker(nil)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !slices.Equal(statuses, []string{models.WithdrawalStatusPending}) {
+		t.Errorf("listed statuses %v, want [%s]", statuses, models.WithdrawalStatusPending)
+	}
+	if err := openapi.CheckResponse(http.MethodGet, "/api/user/withdrawals", rec.Code, rec.Header(), rec.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGzipRouteBodySize(t *testing.T) {
+	cfg, err := config.Load([]string{"-d", "postgres://gzip", "-r", "http://accrual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Configure("gzip-secret", time.Hour)
+	token, err := auth.BuildJWTString(contractUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewLoyaltyService(&servicetest.Store{}, service.Limits{}, nil)
+	r := newRouter(cfg, svc, events.NewHub(nil), health.NewChecker(nil))
+
+	// The order route takes 1 KiB, far below the global decompressed size limit.
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(validOrder + strings.Repeat(" ", orderBodySize)))
+	zw.Close()
+	if int64(body.Len()) >= orderBodySize || cfg.MaxDecompressedBodySize <= orderBodySize {
+		t.Fatalf("compressed body of %d bytes does not exercise the decompressed limit", body.Len())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", &body)
+	req.Header.Set("Cookie", "cookie="+token)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+	}
+}
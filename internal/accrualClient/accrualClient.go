@@ -5,13 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/KirillZiborov/go-loyalty-program/internal/config"
 	"github.com/KirillZiborov/go-loyalty-program/internal/database"
 	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
 	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,59 +24,166 @@ type AccrualResponse struct {
 	Accrual float32 `json:"accrual,omitempty"`
 }
 
-func StartAccrual(cfg *config.Config, ctx context.Context, db *pgxpool.Pool) {
-	ticker := time.NewTicker(5 * time.Second)
+// StartAccrual polls pending orders until ctx is done. Settings are read from
+// cfgs on every poll, so reloaded worker count, interval and rate limit apply
+// from the next batch on.
+func StartAccrual(cfgs *config.Reloader, ctx context.Context, db *pgxpool.Pool) {
+	interval := cfgs.Current().AccrualPollInterval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			ProccessPendingOrders(cfg, ctx, db, 5)
+			cfg := cfgs.Current()
+			rateLimiter.SetRate(cfg.AccrualRateLimit)
+			ProccessPendingOrders(cfg, ctx, db, cfg.AccrualWorkers)
+
+			if cfg.AccrualPollInterval != interval {
+				interval = cfg.AccrualPollInterval
+				ticker.Reset(interval)
+			}
 		case <-ctx.Done():
-			logging.Sugar.Infow("Accrual process finished")
+			logging.FromContext(ctx).Infow("Accrual process finished")
 			return
 		}
 
 	}
 }
 
-func GetAccrual(cfg *config.Config, orderNumber string) (*AccrualResponse, error) {
-	url := fmt.Sprintf("%s%s%s", cfg.SysAdress, "/api/orders/", orderNumber)
-	resp, err := http.Get(url)
+// maxAccrualAttempts bounds the requests made for one order when the accrual
+// system keeps answering 429 Too Many Requests.
+const maxAccrualAttempts = 5
+
+// maxRetryAfter caps the pause asked for by a Retry-After header.
+const maxRetryAfter = time.Minute
+
+// GetAccrual asks the accrual system at address about the order, an empty
+// address means the configured one. When the system is throttling, the
+// Retry-After pause holds back every request through the shared limiter.
+func GetAccrual(ctx context.Context, cfg *config.Config, address, orderNumber string) (*AccrualResponse, error) {
+	ctx, span := tracing.Start(ctx, "accrual.GetAccrual")
+	defer span.End()
+	span.SetAttribute("order.number", orderNumber)
+
+	if address == "" {
+		address = cfg.SysAdress
+	}
+	url := fmt.Sprintf("%s%s%s", address, "/api/orders/", orderNumber)
+	client := &http.Client{Timeout: cfg.AccrualTimeout}
+
+	for attempt := 1; ; attempt++ {
+		if err := rateLimiter.Wait(ctx); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		accrual, retryAfter, err := requestAccrual(ctx, span, client, url, orderNumber)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if retryAfter == 0 {
+			return accrual, nil
+		}
+
+		metrics.AccrualThrottled.Inc()
+		if attempt == maxAccrualAttempts {
+			err := fmt.Errorf("accrual server still throttling after %d attempts", attempt)
+			span.RecordError(err)
+			return nil, err
+		}
+		metrics.AccrualRetries.Inc()
+		rateLimiter.Backoff(retryAfter)
+	}
+}
+
+// requestAccrual makes a single request. It returns the pause asked for if the
+// accrual system answered 429 Too Many Requests.
+func requestAccrual(ctx context.Context, span *tracing.Span, client *http.Client, url, orderNumber string) (*AccrualResponse, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		logging.Sugar.Errorw("Get request to accrual service failed", "url", url, "error", err)
-		return nil, fmt.Errorf("get request to acrual failed: %w", err)
+		return nil, 0, fmt.Errorf("failed to build accrual request: %w", err)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	recordContact(err == nil)
+	if err != nil {
+		metrics.AccrualRequests.Inc("error")
+		metrics.AccrualRequestDuration.Observe(time.Since(start).Seconds(), "error")
+		logging.FromContext(ctx).Errorw("Get request to accrual service failed", "url", url, "error", err)
+		return nil, 0, fmt.Errorf("get request to acrual failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	code := strconv.Itoa(resp.StatusCode)
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	metrics.AccrualRequests.Inc(code)
+	metrics.AccrualRequestDuration.Observe(time.Since(start).Seconds(), code)
+
 	switch resp.StatusCode {
 	case http.StatusOK:
 		var accrual AccrualResponse
 		if err := json.NewDecoder(resp.Body).Decode(&accrual); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+			return nil, 0, fmt.Errorf("failed to decode response: %w", err)
 		}
-		return &accrual, nil
+		return &accrual, 0, nil
 	case http.StatusNoContent:
-		return nil, nil
+		return nil, 0, nil
 	case http.StatusTooManyRequests:
-		retryAfter := resp.Header.Get("Retry-After")
-		retryDuration, _ := time.ParseDuration(retryAfter + "s")
-		time.Sleep(retryDuration)
-		return GetAccrual(cfg, orderNumber)
+		return nil, retryAfter(resp.Header.Get("Retry-After")), nil
 	default:
-		logging.Sugar.Errorw("Accrual server returned unexpected status", "orderNumber", orderNumber, "status", resp.Status)
-		return nil, fmt.Errorf("accrual server error: %s", resp.Status)
+		logging.FromContext(ctx).Errorw("Accrual server returned unexpected status", "orderNumber", orderNumber, "status", resp.Status)
+		return nil, 0, fmt.Errorf("accrual server error: %s", resp.Status)
 	}
 }
 
+// retryAfter parses a Retry-After header given in seconds. Missing or invalid
+// values mean one second, larger values than maxRetryAfter are capped.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryAfter)
+}
+
 func ProccessPendingOrders(cfg *config.Config, ctx context.Context, db *pgxpool.Pool, workerCount int) {
+	ctx, span := tracing.Start(ctx, "accrual.ProccessPendingOrders")
+	defer span.End()
+	ctx = logging.NewContext(ctx, &logging.Poller)
+
+	start := time.Now()
+	defer func() {
+		metrics.AccrualBatchDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	orders, err := database.GetPendingOrders(ctx, db)
 	if err != nil {
-		logging.Sugar.Errorw("Error fetching pending orders", "error", err)
+		logging.FromContext(ctx).Errorw("Error fetching pending orders", "error", err)
+		recordRun(0, err)
 		return
 	}
+	metrics.AccrualPendingOrders.Set(float64(len(orders)))
+	span.SetAttribute("orders.pending", len(orders))
+
+	referral := models.ReferralProgram{
+		Bonus: float32(cfg.ReferralBonus),
+		Cap:   cfg.ReferralCap,
+	}
 
 	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var runErr error
+	fail := func(err error) {
+		errMu.Lock()
+		if runErr == nil {
+			runErr = err
+		}
+		errMu.Unlock()
+	}
 	jobs := make(chan models.Order, len(orders))
 
 	for i := 0; i < workerCount; i++ {
@@ -81,9 +191,10 @@ func ProccessPendingOrders(cfg *config.Config, ctx context.Context, db *pgxpool.
 		go func() {
 			defer wg.Done()
 			for order := range jobs {
-				accrualResponse, err := GetAccrual(cfg, order.OrderNumber)
+				accrualResponse, err := GetAccrual(ctx, cfg, order.AccrualAddress, order.OrderNumber)
 				if err != nil {
-					logging.Sugar.Errorw("Error retrieving accrual for order", "orderNumber", order.OrderNumber, "error", err)
+					logging.FromContext(ctx).Errorw("Error retrieving accrual for order", "merchantID", order.MerchantID, "orderNumber", order.OrderNumber, "error", err)
+					fail(err)
 					continue
 				}
 
@@ -94,12 +205,16 @@ func ProccessPendingOrders(cfg *config.Config, ctx context.Context, db *pgxpool.
 					accrual = accrualResponse.Accrual
 				}
 
-				err = database.UpdateOrder(ctx, db, order.OrderNumber, status, accrual, order.UserID)
+				changed, err := database.UpdateOrder(ctx, db, order.MerchantID, order.OrderNumber, status, accrual, order.UserID, referral)
 				if err != nil {
-					logging.Sugar.Errorw("Error updating order in database", "orderNumber", order.OrderNumber, "error", err)
+					logging.FromContext(ctx).Errorw("Error updating order in database", "merchantID", order.MerchantID, "orderNumber", order.OrderNumber, "error", err)
+					fail(err)
 					continue
 				}
-				logging.Sugar.Infow("Successfully updated order", "orderNumber", order.OrderNumber, "status", status, "accrual", accrual)
+				if changed && status == models.OrderStatusProcessed {
+					metrics.PointsAccrued.Add(float64(accrual))
+				}
+				logging.FromContext(ctx).Infow("Successfully updated order", "merchantID", order.MerchantID, "orderNumber", order.OrderNumber, "status", status, "accrual", accrual)
 			}
 		}()
 	}
@@ -110,4 +225,6 @@ func ProccessPendingOrders(cfg *config.Config, ctx context.Context, db *pgxpool.
 
 	close(jobs)
 	wg.Wait()
+
+	recordRun(len(orders), runErr)
 }
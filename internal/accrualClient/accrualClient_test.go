@@ -0,0 +1,102 @@
+package accrualclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/config"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", time.Second},
+		{"abc", time.Second},
+		{"0", time.Second},
+		{"-5", time.Second},
+		{"3", 3 * time.Second},
+		{"60", time.Minute},
+		{"86400", maxRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	l := &limiter{}
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait without rate or backoff: %v", err)
+	}
+
+	l.Backoff(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait during backoff = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A shorter backoff does not shorten a pause already in effect.
+	l.Backoff(time.Millisecond)
+	if until := time.Until(l.next); until < 59*time.Minute {
+		t.Fatalf("backoff shortened to %v", until)
+	}
+}
+
+func TestGetAccrualThrottledStopsOnCancel(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { rateLimiter = &limiter{} })
+
+	cfg := &config.Config{SysAdress: srv.URL, AccrualTimeout: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := GetAccrual(ctx, cfg, "", "12345678903")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetAccrual = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("GetAccrual returned after %v, cancellation was not honoured", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("accrual system got %d requests, want 1", n)
+	}
+}
+
+func TestGetAccrualThrottledGivesUp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the minimum Retry-After pause between attempts")
+	}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { rateLimiter = &limiter{} })
+
+	cfg := &config.Config{SysAdress: srv.URL, AccrualTimeout: time.Second}
+	_, err := GetAccrual(context.Background(), cfg, "", "12345678903")
+	if err == nil {
+		t.Fatal("GetAccrual succeeded against a server that always throttles")
+	}
+	if n := requests.Load(); n != maxAccrualAttempts {
+		t.Fatalf("accrual system got %d requests, want %d", n, maxAccrualAttempts)
+	}
+}
@@ -0,0 +1,57 @@
+package accrualclient
+
+import (
+	"sync"
+	"time"
+)
+
+// PollerStatus describes the recent activity of the accrual poller.
+type PollerStatus struct {
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Backlog             int        `json:"backlog"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	AccrualReachable    *bool      `json:"accrual_reachable,omitempty"`
+	LastAccrualContact  *time.Time `json:"last_accrual_contact,omitempty"`
+}
+
+var (
+	statusMu     sync.RWMutex
+	pollerStatus PollerStatus
+)
+
+func Status() PollerStatus {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	return pollerStatus
+}
+
+func recordRun(backlog int, err error) {
+	now := time.Now()
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	pollerStatus.LastRun = &now
+	pollerStatus.Backlog = backlog
+	if err != nil {
+		pollerStatus.LastError = err.Error()
+		pollerStatus.ConsecutiveFailures++
+		return
+	}
+	pollerStatus.LastError = ""
+	pollerStatus.ConsecutiveFailures = 0
+	pollerStatus.LastSuccess = &now
+}
+
+// recordContact notes whether the accrual system answered the last request at all.
+func recordContact(reachable bool) {
+	now := time.Now()
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	pollerStatus.AccrualReachable = &reachable
+	if reachable {
+		pollerStatus.LastAccrualContact = &now
+	}
+}
@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
 	"github.com/golang-jwt/jwt/v4"
 )
 
@@ -14,8 +18,24 @@ type Claims struct {
 	UserID int `json:"user_id"`
 }
 
-const TokenExp = time.Hour * 3
-const SecretKey = "supersecretkey"
+var (
+	TokenExp  = time.Hour * 3
+	secretKey []byte
+)
+
+// Configure sets the token signing secret and lifetime. An empty secret is
+// replaced with a random one, so tokens do not survive a restart.
+func Configure(secret string, ttl time.Duration) {
+	if secret == "" {
+		logging.Sugar.Warnw("No JWT secret configured, using a random one")
+		key := make([]byte, 32)
+		rand.Read(key)
+		secretKey = key
+	} else {
+		secretKey = []byte(secret)
+	}
+	TokenExp = ttl
+}
 
 func GenerateToken(userID int) (string, error) {
 	if userID == 0 {
@@ -39,7 +59,7 @@ func BuildJWTString(userID int) (string, error) {
 		UserID: userID,
 	})
 
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokenString, err := token.SignedString(secretKey)
 	if err != nil {
 		return "", err
 	}
@@ -50,7 +70,7 @@ func BuildJWTString(userID int) (string, error) {
 func GetUserID(tokenString string) (int, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil
+		return secretKey, nil
 	})
 	if err != nil || !token.Valid {
 		return 0, fmt.Errorf("invalid token")
@@ -62,8 +82,7 @@ func GetUserID(tokenString string) (int, error) {
 func AuthPost(w http.ResponseWriter, r *http.Request, userID int) error {
 	token, err := GenerateToken(userID)
 	if err != nil {
-		logging.Sugar.Errorw("Error while generating token", "error", err)
-		http.Error(w, "Error while generating token", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Errorw("Error while generating token", "error", err)
 		return err
 	}
 
@@ -81,16 +100,31 @@ func AuthPost(w http.ResponseWriter, r *http.Request, userID int) error {
 func AuthGet(r *http.Request) (int, error) {
 	cookie, err := r.Cookie("cookie")
 	if err != nil {
-		logging.Sugar.Errorw("Cookie not found", "error", err)
+		logging.FromContext(r.Context()).Infow("Cookie not found", "error", err)
 		return 0, err
 	}
 
 	userID, err := GetUserID(cookie.Value)
 	if err != nil {
-		logging.Sugar.Errorw("Error extracting userID", "error", err)
+		logging.FromContext(r.Context()).Warnw("Error extracting userID", "error", err)
 		return 0, err
 	}
 
-	logging.Sugar.Infof("Auth userID: %d", userID)
+	logging.WithUserID(r.Context(), userID)
+	logging.FromContext(r.Context()).Debugw("Authenticated request")
 	return userID, err
 }
+
+// AdminMiddleware allows only requests carrying "Authorization: Bearer <token>".
+func AdminMiddleware(token string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				response.Unauthorized(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package campaigns
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+)
+
+const (
+	StatusActive = "ACTIVE"
+	StatusPaused = "PAUSED"
+)
+
+var ErrorInvalidCampaign = errors.New("invalid campaign")
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func Validate(req *models.CampaignRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.Join(ErrorInvalidCampaign, errors.New("name is required"))
+	}
+	if req.StartsAt.IsZero() || req.EndsAt.IsZero() || !req.EndsAt.After(req.StartsAt) {
+		return errors.Join(ErrorInvalidCampaign, errors.New("ends_at must be after starts_at"))
+	}
+	if req.Eligibility.MinAccrual < 0 {
+		return errors.Join(ErrorInvalidCampaign, errors.New("min_accrual must not be negative"))
+	}
+	for _, day := range req.Eligibility.Weekdays {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return errors.Join(ErrorInvalidCampaign, errors.New("unknown weekday "+day))
+		}
+	}
+	if slices.Contains(req.Eligibility.Merchants, "") {
+		return errors.Join(ErrorInvalidCampaign, errors.New("merchant codes must not be empty"))
+	}
+
+	b := req.Bonus
+	if b.Multiplier < 0 || b.Fixed < 0 || b.Max < 0 {
+		return errors.Join(ErrorInvalidCampaign, errors.New("bonus values must not be negative"))
+	}
+	if b.Multiplier != 0 && b.Multiplier < 1 {
+		return errors.Join(ErrorInvalidCampaign, errors.New("multiplier must be at least 1"))
+	}
+	if b.Multiplier <= 1 && b.Fixed == 0 {
+		return errors.Join(ErrorInvalidCampaign, errors.New("bonus must define multiplier or fixed points"))
+	}
+	return nil
+}
+
+// Running reports whether the campaign is active and t falls into its date range.
+func Running(c *models.Campaign, t time.Time) bool {
+	return c.Status == StatusActive && !t.Before(c.StartsAt) && t.Before(c.EndsAt)
+}
+
+func Eligible(c *models.Campaign, facts models.OrderFacts) bool {
+	e := c.Eligibility
+	if e.FirstOrderOnly && !facts.FirstOrder {
+		return false
+	}
+	if facts.Accrual < e.MinAccrual {
+		return false
+	}
+	if len(e.Weekdays) > 0 {
+		day := facts.ProcessedAt.UTC().Weekday()
+		matched := false
+		for _, name := range e.Weekdays {
+			if weekdays[strings.ToLower(name)] == day {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(e.Merchants) > 0 && !slices.Contains(e.Merchants, facts.Merchant) {
+		return false
+	}
+	return true
+}
+
+func Bonus(c *models.Campaign, accrual float32) float32 {
+	var bonus float32
+	if c.Bonus.Multiplier > 1 {
+		bonus += accrual * (c.Bonus.Multiplier - 1)
+	}
+	bonus += c.Bonus.Fixed
+	if c.Bonus.Max > 0 && bonus > c.Bonus.Max {
+		bonus = c.Bonus.Max
+	}
+	return bonus
+}
+
+// Evaluate returns the bonus the campaign grants for the order, or 0 if it does not apply.
+func Evaluate(c *models.Campaign, facts models.OrderFacts) float32 {
+	if !Running(c, facts.ProcessedAt) || !Eligible(c, facts) {
+		return 0
+	}
+	return Bonus(c, facts.Accrual)
+}
@@ -1,43 +1,500 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
 )
 
 type Config struct {
-	Address   string
-	DBPath    string
-	SysAdress string
+	Address    string
+	DBPath     string
+	SysAdress  string
+	AdminToken string
+
+	JWTSecret string
+	TokenTTL  time.Duration
+
+	AccrualPollInterval time.Duration
+	AccrualWorkers      int
+	AccrualTimeout      time.Duration
+	AccrualRateLimit    float64
+
+	DBMaxConns        int
+	DBMinConns        int
+	DBMaxConnLifetime time.Duration
+	DBMaxConnIdleTime time.Duration
+	DBConnectTimeout  time.Duration
+
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	MaxBodySize             int64
+	MaxDecompressedBodySize int64
+
+	GRPCAddress       string
+	GRPCWatchInterval time.Duration
+
+	EventsHeartbeatInterval time.Duration
+	EventsRetention         time.Duration
+	WSAllowedOrigins        string
+
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	TLSReloadInterval time.Duration
+	RedirectAddress   string
+
+	TransferDailyLimit float64
+	OrderBatchLimit    int
+
+	RedemptionMin          float64
+	RedemptionMax          float64
+	RedemptionMaxPercent   float64
+	RedemptionOncePerOrder bool
+
+	WithdrawalHoldTTL        time.Duration
+	WithdrawalExpiryInterval time.Duration
+	QuoteTTL                 time.Duration
+
+	// OrderNumberProfiles is the JSON encoding of the merchants' order number
+	// formats, see ordernumber.ParseProfiles.
+	OrderNumberProfiles string
+	ReferralBonus       float64
+	ReferralCap         int
+	// ReferralRejectSameIP refuses referral codes used from the referrer's sign-up address.
+	ReferralRejectSameIP bool
+	// SignupIPRetention is how long sign-up addresses are kept for that check.
+	SignupIPRetention time.Duration
+	// ClientIPHeader names the header a trusted proxy sets to the client address.
+	ClientIPHeader string
+
+	TraceExporter string
+	TraceFile     string
+
+	LogLevel  string
+	LogFormat string
+
+	ConfigWatchInterval time.Duration
+
+	OpenAPIValidateResponses bool
+
+	// ConfigFile is the file the configuration was loaded from, if any.
+	ConfigFile string
 }
 
-func NewConfig() *Config {
+// option describes a single setting. Every option is a flag; key is its name in
+// the config file and env is the environment variable overriding the file.
+// Secret options also accept <key>_file / <ENV>_FILE / -<flag>-file pointing to a file holding the value.
+// JSON options take a JSON document, which config files may write as a nested value.
+type option struct {
+	flag   string
+	key    string
+	env    string
+	secret bool
+	json   bool
+}
+
+var options = []option{
+	{flag: "a", key: "address", env: "RUN_ADDRESS"},
+	{flag: "d", key: "database_uri", env: "DATABASE_URI", secret: true},
+	{flag: "r", key: "accrual_system_address", env: "ACCRUAL_SYSTEM_ADDRESS"},
+	{flag: "admin-token", key: "admin_token", env: "ADMIN_TOKEN", secret: true},
+	{flag: "jwt-secret", key: "jwt_secret", env: "JWT_SECRET", secret: true},
+	{flag: "token-ttl", key: "token_ttl", env: "TOKEN_TTL"},
+	{flag: "accrual-poll-interval", key: "accrual_poll_interval", env: "ACCRUAL_POLL_INTERVAL"},
+	{flag: "accrual-workers", key: "accrual_workers", env: "ACCRUAL_WORKERS"},
+	{flag: "accrual-timeout", key: "accrual_timeout", env: "ACCRUAL_TIMEOUT"},
+	{flag: "accrual-rate-limit", key: "accrual_rate_limit", env: "ACCRUAL_RATE_LIMIT"},
+	{flag: "db-max-conns", key: "db_max_conns", env: "DB_MAX_CONNS"},
+	{flag: "db-min-conns", key: "db_min_conns", env: "DB_MIN_CONNS"},
+	{flag: "db-max-conn-lifetime", key: "db_max_conn_lifetime", env: "DB_MAX_CONN_LIFETIME"},
+	{flag: "db-max-conn-idle-time", key: "db_max_conn_idle_time", env: "DB_MAX_CONN_IDLE_TIME"},
+	{flag: "db-connect-timeout", key: "db_connect_timeout", env: "DB_CONNECT_TIMEOUT"},
+	{flag: "shutdown-drain-delay", key: "shutdown_drain_delay", env: "SHUTDOWN_DRAIN_DELAY"},
+	{flag: "shutdown-timeout", key: "shutdown_timeout", env: "SHUTDOWN_TIMEOUT"},
+	{flag: "read-header-timeout", key: "read_header_timeout", env: "READ_HEADER_TIMEOUT"},
+	{flag: "read-timeout", key: "read_timeout", env: "READ_TIMEOUT"},
+	{flag: "write-timeout", key: "write_timeout", env: "WRITE_TIMEOUT"},
+	{flag: "idle-timeout", key: "idle_timeout", env: "IDLE_TIMEOUT"},
+	{flag: "max-body-size", key: "max_body_size", env: "MAX_BODY_SIZE"},
+	{flag: "max-decompressed-body-size", key: "max_decompressed_body_size", env: "MAX_DECOMPRESSED_BODY_SIZE"},
+	{flag: "grpc-address", key: "grpc_address", env: "GRPC_ADDRESS"},
+	{flag: "grpc-watch-interval", key: "grpc_watch_interval", env: "GRPC_WATCH_INTERVAL"},
+	{flag: "events-heartbeat-interval", key: "events_heartbeat_interval", env: "EVENTS_HEARTBEAT_INTERVAL"},
+	{flag: "events-retention", key: "events_retention", env: "EVENTS_RETENTION"},
+	{flag: "ws-allowed-origins", key: "ws_allowed_origins", env: "WS_ALLOWED_ORIGINS"},
+	{flag: "tls-cert", key: "tls_cert_file", env: "TLS_CERT_FILE"},
+	{flag: "tls-key", key: "tls_key_file", env: "TLS_KEY_FILE"},
+	{flag: "tls-client-ca", key: "tls_client_ca_file", env: "TLS_CLIENT_CA_FILE"},
+	{flag: "tls-reload-interval", key: "tls_reload_interval", env: "TLS_RELOAD_INTERVAL"},
+	{flag: "redirect-address", key: "redirect_address", env: "REDIRECT_ADDRESS"},
+	{flag: "transfer-limit", key: "transfer_daily_limit", env: "TRANSFER_DAILY_LIMIT"},
+	{flag: "order-batch-limit", key: "order_batch_limit", env: "ORDER_BATCH_LIMIT"},
+	{flag: "redemption-min", key: "redemption_min", env: "REDEMPTION_MIN"},
+	{flag: "redemption-max", key: "redemption_max", env: "REDEMPTION_MAX"},
+	{flag: "redemption-max-percent", key: "redemption_max_percent", env: "REDEMPTION_MAX_PERCENT"},
+	{flag: "redemption-once-per-order", key: "redemption_once_per_order", env: "REDEMPTION_ONCE_PER_ORDER"},
+	{flag: "withdrawal-hold-ttl", key: "withdrawal_hold_ttl", env: "WITHDRAWAL_HOLD_TTL"},
+	{flag: "withdrawal-expiry-interval", key: "withdrawal_expiry_interval", env: "WITHDRAWAL_EXPIRY_INTERVAL"},
+	{flag: "quote-ttl", key: "quote_ttl", env: "QUOTE_TTL"},
+	{flag: "order-number-profiles", key: "order_number_profiles", env: "ORDER_NUMBER_PROFILES", json: true},
+	{flag: "referral-bonus", key: "referral_bonus", env: "REFERRAL_BONUS"},
+	{flag: "referral-cap", key: "referral_cap", env: "REFERRAL_CAP"},
+	{flag: "referral-reject-same-ip", key: "referral_reject_same_ip", env: "REFERRAL_REJECT_SAME_IP"},
+	{flag: "signup-ip-retention", key: "signup_ip_retention", env: "SIGNUP_IP_RETENTION"},
+	{flag: "client-ip-header", key: "client_ip_header", env: "CLIENT_IP_HEADER"},
+	{flag: "trace-exporter", key: "trace_exporter", env: "TRACE_EXPORTER"},
+	{flag: "trace-file", key: "trace_file", env: "TRACE_FILE"},
+	{flag: "log-level", key: "log_level", env: "LOG_LEVEL"},
+	{flag: "log-format", key: "log_format", env: "LOG_FORMAT"},
+	{flag: "config-watch-interval", key: "config_watch_interval", env: "CONFIG_WATCH_INTERVAL"},
+	{flag: "openapi-validate-responses", key: "openapi_validate_responses", env: "OPENAPI_VALIDATE_RESPONSES"},
+}
+
+const configFlag = "config"
+
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.ConfigFile, configFlag, "", "Path to a YAML or JSON config file (env CONFIG)")
+	fs.StringVar(&cfg.Address, "a", "localhost:8080", "Address of the HTTP server")
+	fs.StringVar(&cfg.DBPath, "d", "", "Database address")
+	fs.StringVar(&cfg.SysAdress, "r", "", "Address of the acrual system")
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "Bearer token for the admin API, admin API is disabled if empty")
+	fs.StringVar(&cfg.JWTSecret, "jwt-secret", "", "Secret for signing auth tokens, a random one is generated if empty")
+	fs.DurationVar(&cfg.TokenTTL, "token-ttl", 3*time.Hour, "Lifetime of auth tokens")
+	fs.DurationVar(&cfg.AccrualPollInterval, "accrual-poll-interval", 5*time.Second, "Interval between polls of pending orders")
+	fs.IntVar(&cfg.AccrualWorkers, "accrual-workers", 5, "Number of concurrent requests to the accrual system")
+	fs.DurationVar(&cfg.AccrualTimeout, "accrual-timeout", 10*time.Second, "Timeout of a single request to the accrual system")
+	fs.Float64Var(&cfg.AccrualRateLimit, "accrual-rate-limit", 0, "Maximum requests per second to the accrual system, 0 means unlimited")
+	fs.IntVar(&cfg.DBMaxConns, "db-max-conns", 10, "Maximum number of database connections")
+	fs.IntVar(&cfg.DBMinConns, "db-min-conns", 0, "Minimum number of idle database connections")
+	fs.DurationVar(&cfg.DBMaxConnLifetime, "db-max-conn-lifetime", time.Hour, "Maximum lifetime of a database connection")
+	fs.DurationVar(&cfg.DBMaxConnIdleTime, "db-max-conn-idle-time", 30*time.Minute, "Maximum idle time of a database connection")
+	fs.DurationVar(&cfg.DBConnectTimeout, "db-connect-timeout", 5*time.Second, "Timeout for connecting to and preparing the database at startup")
+	fs.DurationVar(&cfg.ShutdownDrainDelay, "shutdown-drain-delay", 3*time.Second, "Time to keep serving with failing readiness before shutting down")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "Time to wait for in-flight requests on shutdown")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Time allowed to read request headers")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 15*time.Second, "Time allowed to read the whole request")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 30*time.Second, "Time allowed to write the response, statement exports are exempt")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "Time to keep idle keep-alive connections open")
+	fs.Int64Var(&cfg.MaxBodySize, "max-body-size", 1<<20, "Maximum size of a request body in bytes")
+	fs.Int64Var(&cfg.MaxDecompressedBodySize, "max-decompressed-body-size", 1<<20, "Maximum size of a gzip request body after decompression in bytes")
+	fs.StringVar(&cfg.GRPCAddress, "grpc-address", "", "Address of the gRPC server, disabled if empty")
+	fs.DurationVar(&cfg.GRPCWatchInterval, "grpc-watch-interval", 2*time.Second, "Interval between order status checks in WatchOrders streams")
+	fs.DurationVar(&cfg.EventsHeartbeatInterval, "events-heartbeat-interval", 15*time.Second, "Interval between heartbeats on event streams and WebSocket pings")
+	fs.DurationVar(&cfg.EventsRetention, "events-retention", 24*time.Hour, "How long events are kept for resuming event streams")
+	fs.StringVar(&cfg.WSAllowedOrigins, "ws-allowed-origins", "", "Comma separated origins allowed to open WebSocket connections from other sites, e.g. https://shop.example.com")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "TLS certificate file, TLS is enabled if set together with -tls-key")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "TLS private key file")
+	fs.StringVar(&cfg.TLSClientCAFile, "tls-client-ca", "", "CA bundle for verifying client certificates, admin routes require one if set")
+	fs.DurationVar(&cfg.TLSReloadInterval, "tls-reload-interval", 30*time.Second, "Interval between checks of the certificate files for changes")
+	fs.StringVar(&cfg.RedirectAddress, "redirect-address", "", "Address of a plain HTTP listener redirecting to HTTPS, disabled if empty")
+	fs.Float64Var(&cfg.TransferDailyLimit, "transfer-limit", 1000, "Maximum amount of points a user can transfer per day, 0 means unlimited")
+	fs.IntVar(&cfg.OrderBatchLimit, "order-batch-limit", 500, "Maximum number of order numbers in a batch upload")
+	fs.Float64Var(&cfg.RedemptionMin, "redemption-min", 0, "Minimum amount of points in a single withdrawal, 0 means no minimum")
+	fs.Float64Var(&cfg.RedemptionMax, "redemption-max", 0, "Maximum amount of points in a single withdrawal, 0 means no maximum")
+	fs.Float64Var(&cfg.RedemptionMaxPercent, "redemption-max-percent", 100, "Maximum percentage of the order value payable with points when the order value is supplied, 0 means no cap")
+	fs.BoolVar(&cfg.RedemptionOncePerOrder, "redemption-once-per-order", true, "Allow only one withdrawal per order number")
+	fs.DurationVar(&cfg.WithdrawalHoldTTL, "withdrawal-hold-ttl", 15*time.Minute, "Time a pending withdrawal reserves points before it is released unless confirmed")
+	fs.DurationVar(&cfg.WithdrawalExpiryInterval, "withdrawal-expiry-interval", 30*time.Second, "Interval between releases of expired pending withdrawals and quotes")
+	fs.DurationVar(&cfg.QuoteTTL, "quote-ttl", 2*time.Minute, "Time a quote holds the redeemable points for a withdrawal")
+	fs.StringVar(&cfg.OrderNumberProfiles, "order-number-profiles", "", `Order number formats by merchant as JSON, e.g. {"acme": {"checksum": "none", "min_length": 10, "max_length": 12, "prefixes": ["77"]}}`)
+	fs.Float64Var(&cfg.ReferralBonus, "referral-bonus", 50, "Points credited to the referrer once the referred user's first order is processed")
+	fs.IntVar(&cfg.ReferralCap, "referral-cap", 20, "Maximum number of rewarded referrals per referrer, 0 means unlimited")
+	fs.BoolVar(&cfg.ReferralRejectSameIP, "referral-reject-same-ip", false, "Reject referral codes used from the address the referrer signed up from, sign-up addresses are only stored when enabled")
+	fs.DurationVar(&cfg.SignupIPRetention, "signup-ip-retention", 30*24*time.Hour, "How long sign-up addresses are kept for the same address referral check")
+	fs.StringVar(&cfg.ClientIPHeader, "client-ip-header", "", "Header set by a trusted proxy to the client address, such as X-Real-IP or X-Forwarded-For, the connection address is used if empty")
+	fs.StringVar(&cfg.TraceExporter, "trace-exporter", "none", "Trace exporter: none, stdout or file")
+	fs.StringVar(&cfg.TraceFile, "trace-file", "traces.jsonl", "File spans are appended to when the file trace exporter is used")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", "json", "Log format: json or console")
+	fs.BoolVar(&cfg.OpenAPIValidateResponses, "openapi-validate-responses", false, "Log responses that do not match the OpenAPI document")
+	fs.DurationVar(&cfg.ConfigWatchInterval, "config-watch-interval", 5*time.Second, "Interval between checks of the config file for changes, 0 disables watching")
+
+	for _, o := range options {
+		if o.secret {
+			fs.String(o.flag+"-file", "", fmt.Sprintf("File to read -%s from", o.flag))
+		}
+	}
+	return fs
+}
+
+// Load builds the configuration from defaults, the config file, environment
+// variables and command line flags, each layer overriding the previous one.
+func Load(args []string) (*Config, error) {
+	// Flags are parsed first only to find -config and remember what was set explicitly.
+	parsed := &Config{}
+	pfs := newFlagSet(parsed)
+	if err := pfs.Parse(args); err != nil {
+		return nil, err
+	}
+	if pfs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(pfs.Args(), " "))
+	}
+	explicit := make(map[string]string)
+	pfs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
 	cfg := &Config{}
+	fs := newFlagSet(cfg)
+
+	configFile := parsed.ConfigFile
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG")
+	}
+	if configFile != "" {
+		if err := loadFile(fs, configFile); err != nil {
+			return nil, err
+		}
+		cfg.ConfigFile = configFile
+	}
+	// Secret files are read within their layer, so a later layer's value wins
+	// over a file named by an earlier one.
+	for _, o := range secretOptions() {
+		if err := readSecretFile(fs, o, fs.Lookup(o.flag+"-file").Value.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range options {
+		if v, ok := os.LookupEnv(o.env); ok && v != "" {
+			if err := fs.Set(o.flag, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", o.env, err)
+			}
+		}
+		if o.secret {
+			if err := readSecretFile(fs, o, os.Getenv(o.env+"_FILE")); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, v := range explicit {
+		if err := fs.Set(name, v); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %w", name, err)
+		}
+	}
+	for _, o := range secretOptions() {
+		if err := readSecretFile(fs, o, explicit[o.flag+"-file"]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	flag.StringVar(&cfg.Address, "a", "localhost:8080", "Address of the HTTP server")
-	flag.StringVar(&cfg.SysAdress, "r", "http://localhost:8080", "Address of the acrual system")
-	flag.StringVar(&cfg.DBPath, "d", "", "Database address")
+func secretOptions() []option {
+	var secrets []option
+	for _, o := range options {
+		if o.secret {
+			secrets = append(secrets, o)
+		}
+	}
+	return secrets
+}
 
-	flag.Parse()
+// readSecretFile sets the secret o to the contents of the file at path. An empty path is ignored.
+func readSecretFile(fs *flag.FlagSet, o option, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", o.key, err)
+	}
+	return fs.Set(o.flag, strings.TrimSpace(string(data)))
+}
 
-	envAddress := os.Getenv("RUN_ADDRESS")
-	sysAddress := os.Getenv("ACCRUAL_SYSTEM_ADDRESS")
+func (cfg *Config) Validate() error {
+	var errs []error
 
-	if envAddress != "" {
-		cfg.Address = envAddress
-	} else if cfg.Address == "" {
-		cfg.Address = "localhost:8080"
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		errs = append(errs, fmt.Errorf("address: %w", err))
+	}
+	if cfg.DBPath == "" {
+		errs = append(errs, errors.New("database_uri is required"))
+	}
+	if cfg.SysAdress == "" {
+		errs = append(errs, errors.New("accrual_system_address is required"))
+	} else if u, err := url.Parse(cfg.SysAdress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("accrual_system_address must be an http(s) URL, got %q", cfg.SysAdress))
 	}
 
-	if sysAddress != "" {
-		cfg.SysAdress = sysAddress
-	} else if cfg.SysAdress == "" {
-		cfg.SysAdress = "http://" + cfg.Address
+	positive := map[string]time.Duration{
+		"token_ttl":                  cfg.TokenTTL,
+		"accrual_poll_interval":      cfg.AccrualPollInterval,
+		"accrual_timeout":            cfg.AccrualTimeout,
+		"db_connect_timeout":         cfg.DBConnectTimeout,
+		"db_max_conn_lifetime":       cfg.DBMaxConnLifetime,
+		"db_max_conn_idle_time":      cfg.DBMaxConnIdleTime,
+		"shutdown_timeout":           cfg.ShutdownTimeout,
+		"read_header_timeout":        cfg.ReadHeaderTimeout,
+		"read_timeout":               cfg.ReadTimeout,
+		"write_timeout":              cfg.WriteTimeout,
+		"idle_timeout":               cfg.IdleTimeout,
+		"events_heartbeat_interval":  cfg.EventsHeartbeatInterval,
+		"events_retention":           cfg.EventsRetention,
+		"withdrawal_hold_ttl":        cfg.WithdrawalHoldTTL,
+		"withdrawal_expiry_interval": cfg.WithdrawalExpiryInterval,
+		"quote_ttl":                  cfg.QuoteTTL,
+		"signup_ip_retention":        cfg.SignupIPRetention,
+	}
+	for key, d := range positive {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", key))
+		}
+	}
+	if cfg.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("shutdown_drain_delay must not be negative"))
 	}
 
-	if dbPath := os.Getenv("DATABASE_URI"); dbPath != "" {
-		cfg.DBPath = dbPath
+	if cfg.GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.GRPCAddress); err != nil {
+			errs = append(errs, fmt.Errorf("grpc_address: %w", err))
+		}
+		if cfg.GRPCWatchInterval <= 0 {
+			errs = append(errs, errors.New("grpc_watch_interval must be positive"))
+		}
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if cfg.TLSCertFile == "" && (cfg.TLSClientCAFile != "" || cfg.RedirectAddress != "") {
+		errs = append(errs, errors.New("tls_client_ca_file and redirect_address require TLS"))
+	}
+	if cfg.TLSCertFile != "" && cfg.TLSReloadInterval <= 0 {
+		errs = append(errs, errors.New("tls_reload_interval must be positive"))
+	}
+	if cfg.RedirectAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.RedirectAddress); err != nil {
+			errs = append(errs, fmt.Errorf("redirect_address: %w", err))
+		}
+	}
+	for _, origin := range cfg.AllowedOrigins() {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("ws_allowed_origins: %q is not an origin like https://example.com", origin))
+		}
+	}
+	if cfg.MaxBodySize < 1 {
+		errs = append(errs, errors.New("max_body_size must be positive"))
+	}
+	if cfg.MaxDecompressedBodySize < 1 {
+		errs = append(errs, errors.New("max_decompressed_body_size must be positive"))
+	}
+	if _, err := ordernumber.ParseProfiles(cfg.OrderNumberProfiles); err != nil {
+		errs = append(errs, fmt.Errorf("order_number_profiles: %w", err))
+	}
+	if cfg.OrderBatchLimit < 1 {
+		errs = append(errs, errors.New("order_batch_limit must be at least 1"))
+	}
+	if cfg.AccrualWorkers < 1 {
+		errs = append(errs, errors.New("accrual_workers must be at least 1"))
+	}
+	if cfg.DBMaxConns < 1 {
+		errs = append(errs, errors.New("db_max_conns must be at least 1"))
+	}
+	if cfg.DBMinConns < 0 || cfg.DBMinConns > cfg.DBMaxConns {
+		errs = append(errs, errors.New("db_min_conns must be between 0 and db_max_conns"))
+	}
+	if cfg.TransferDailyLimit < 0 {
+		errs = append(errs, errors.New("transfer_daily_limit must not be negative"))
+	}
+	if cfg.RedemptionMin < 0 || cfg.RedemptionMax < 0 {
+		errs = append(errs, errors.New("redemption_min and redemption_max must not be negative"))
+	} else if cfg.RedemptionMax > 0 && cfg.RedemptionMax < cfg.RedemptionMin {
+		errs = append(errs, errors.New("redemption_max must not be less than redemption_min"))
+	}
+	if cfg.RedemptionMaxPercent < 0 || cfg.RedemptionMaxPercent > 100 {
+		errs = append(errs, errors.New("redemption_max_percent must be between 0 and 100"))
+	}
+	if cfg.AccrualRateLimit < 0 {
+		errs = append(errs, errors.New("accrual_rate_limit must not be negative"))
+	}
+	if cfg.ConfigWatchInterval < 0 {
+		errs = append(errs, errors.New("config_watch_interval must not be negative"))
+	}
+	if cfg.ReferralBonus < 0 {
+		errs = append(errs, errors.New("referral_bonus must not be negative"))
+	}
+	if cfg.ReferralCap < 0 {
+		errs = append(errs, errors.New("referral_cap must not be negative"))
+	}
+
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error, got %q", cfg.LogLevel))
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "console" {
+		errs = append(errs, fmt.Errorf("log_format must be json or console, got %q", cfg.LogFormat))
+	}
+	switch cfg.TraceExporter {
+	case "none", "stdout":
+	case "file":
+		if cfg.TraceFile == "" {
+			errs = append(errs, errors.New("trace_file is required for the file trace exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("trace_exporter must be none, stdout or file, got %q", cfg.TraceExporter))
 	}
 
-	return cfg
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration with secrets redacted.
+func (cfg *Config) Print(w io.Writer) error {
+	snapshot := &Config{}
+	fs := newFlagSet(snapshot)
+	*snapshot = *cfg
+
+	if cfg.ConfigFile != "" {
+		if _, err := fmt.Fprintf(w, "# loaded from %s\n", cfg.ConfigFile); err != nil {
+			return err
+		}
+	}
+	for _, o := range options {
+		v := fs.Lookup(o.flag).Value.String()
+		if o.secret && v != "" {
+			v = "[REDACTED]"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %q\n", o.key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != ""
+}
+
+// AllowedOrigins returns the entries of WSAllowedOrigins.
+func (cfg *Config) AllowedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(cfg.WSAllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
 }
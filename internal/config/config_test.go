@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// required are the settings without defaults.
+var required = []string{"-d", "postgres://flag", "-r", "http://accrual:8080"}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "address: file:1\naccrual_workers: 2\ntoken_ttl: 1h\n")
+	t.Setenv("CONFIG", file)
+	t.Setenv("ACCRUAL_WORKERS", "3")
+	t.Setenv("TOKEN_TTL", "2h")
+
+	cfg, err := Load(append([]string{"-token-ttl", "3h"}, required...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "file:1" {
+		t.Errorf("address = %q, want the file value", cfg.Address)
+	}
+	if cfg.AccrualWorkers != 3 {
+		t.Errorf("accrual_workers = %d, want the env value", cfg.AccrualWorkers)
+	}
+	if cfg.TokenTTL.String() != "3h0m0s" {
+		t.Errorf("token_ttl = %v, want the flag value", cfg.TokenTTL)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	envSecret := writeFile(t, "db-env", "postgres://env-file\n")
+	flagSecret := writeFile(t, "db-flag", "postgres://flag-file\n")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{
+			name: "env file",
+			env:  map[string]string{"DATABASE_URI_FILE": envSecret},
+			args: []string{"-r", "http://accrual:8080", "-jwt-secret", "s"},
+			want: "postgres://env-file",
+		},
+		{
+			name: "flag beats env file",
+			env:  map[string]string{"DATABASE_URI_FILE": envSecret},
+			args: required,
+			want: "postgres://flag",
+		},
+		{
+			name: "flag file beats env",
+			env:  map[string]string{"DATABASE_URI": "postgres://env"},
+			args: []string{"-d-file", flagSecret, "-r", "http://accrual:8080", "-jwt-secret", "s"},
+			want: "postgres://flag-file",
+		},
+		{
+			name: "env beats file named in config file",
+			env: map[string]string{
+				"CONFIG":       writeFile(t, "config.json", `{"database_uri_file": "`+flagSecret+`"}`),
+				"DATABASE_URI": "postgres://env",
+			},
+			args: []string{"-r", "http://accrual:8080", "-jwt-secret", "s"},
+			want: "postgres://env",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.DBPath != tt.want {
+				t.Errorf("database_uri = %q, want %q", cfg.DBPath, tt.want)
+			}
+		})
+	}
+}
+
+// TestLaunchContract checks that the address, database and accrual settings
+// are all the service needs to start, given as flags or environment variables.
+func TestLaunchContract(t *testing.T) {
+	cfg, err := Load([]string{"-a", "localhost:8081", "-d", "postgres://db", "-r", "http://accrual:8080"})
+	if err != nil {
+		t.Fatalf("Load with flags: %v", err)
+	}
+	if cfg.JWTSecret != "" {
+		t.Errorf("jwt_secret = %q, want empty so that a random one is generated", cfg.JWTSecret)
+	}
+
+	t.Setenv("RUN_ADDRESS", "localhost:8081")
+	t.Setenv("DATABASE_URI", "postgres://db")
+	t.Setenv("ACCRUAL_SYSTEM_ADDRESS", "http://accrual:8080")
+	if _, err := Load(nil); err != nil {
+		t.Fatalf("Load with environment variables: %v", err)
+	}
+}
+
+// TestSignupIPDefaults checks that sign-up addresses are neither compared nor
+// taken from proxy headers unless configured.
+func TestSignupIPDefaults(t *testing.T) {
+	cfg, err := Load(required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReferralRejectSameIP || cfg.ClientIPHeader != "" {
+		t.Errorf("referral_reject_same_ip = %v, client_ip_header = %q, want both off", cfg.ReferralRejectSameIP, cfg.ClientIPHeader)
+	}
+	if _, err := Load(append(required, "-signup-ip-retention", "0s")); err == nil || !strings.Contains(err.Error(), "signup_ip_retention") {
+		t.Errorf("Load with signup_ip_retention 0 = %v, want an error", err)
+	}
+}
+
+func TestValidateRedemptionMaxPercent(t *testing.T) {
+	tests := []struct {
+		percent string
+		wantErr bool
+	}{
+		{percent: "0"},
+		{percent: "30"},
+		{percent: "100"},
+		{percent: "-1", wantErr: true},
+		{percent: "100.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := Load(append(required, "-redemption-max-percent", tt.percent))
+		if tt.wantErr != (err != nil && strings.Contains(err.Error(), "redemption_max_percent")) {
+			t.Errorf("Load with redemption_max_percent %s = %v, want error %v", tt.percent, err, tt.wantErr)
+		}
+	}
+}
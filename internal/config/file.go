@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile applies settings from a YAML or JSON file. Unknown keys are rejected.
+func loadFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+
+	flags := make(map[string]string)
+	structured := make(map[string]bool)
+	for _, o := range options {
+		flags[o.key] = o.flag
+		structured[o.key] = o.json
+		if o.secret {
+			flags[o.key+"_file"] = o.flag + "-file"
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, ok := flags[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		switch v := values[key].(type) {
+		case map[string]any, []any:
+			if !structured[key] {
+				return fmt.Errorf("config file %s: %s must be a scalar value", path, key)
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %w", path, key, err)
+			}
+			if err := fs.Set(name, string(data)); err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %w", path, key, err)
+			}
+		case nil:
+			continue
+		default:
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %w", path, key, err)
+			}
+		}
+	}
+	return nil
+}
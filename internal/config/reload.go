@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+)
+
+// Reloader holds the current configuration and replaces it on SIGHUP or when
+// the config file changes. Only runtime settings (accrual workers, poll interval,
+// rate limit and log level) take effect; changes to the rest are reported and ignored.
+type Reloader struct {
+	args    []string
+	current atomic.Pointer[Config]
+
+	mu       sync.Mutex
+	onReload []func(*Config)
+}
+
+func NewReloader(cfg *Config, args []string) *Reloader {
+	r := &Reloader{args: args}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current returns the configuration in effect. The returned value must not be modified.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after every successful reload.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.mu.Lock()
+	r.onReload = append(r.onReload, fn)
+	r.mu.Unlock()
+}
+
+// Reload loads the configuration again from all layers. An invalid configuration
+// is rejected and the previous one is kept.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load(r.args)
+	if err != nil {
+		metrics.ConfigReloads.Inc("failure")
+		logging.Sugar.Errorw("Config reload rejected, keeping previous config", "error", err)
+		return err
+	}
+
+	prev := r.current.Load()
+	cfg := *prev
+	cfg.AccrualWorkers = next.AccrualWorkers
+	cfg.AccrualPollInterval = next.AccrualPollInterval
+	cfg.AccrualRateLimit = next.AccrualRateLimit
+	cfg.LogLevel = next.LogLevel
+
+	next.AccrualWorkers = prev.AccrualWorkers
+	next.AccrualPollInterval = prev.AccrualPollInterval
+	next.AccrualRateLimit = prev.AccrualRateLimit
+	next.LogLevel = prev.LogLevel
+	if *next != *prev {
+		logging.Sugar.Warnw("Config reload ignored settings that require a restart")
+	}
+
+	r.current.Store(&cfg)
+	for _, fn := range r.onReload {
+		fn(&cfg)
+	}
+
+	metrics.ConfigReloads.Inc("success")
+	metrics.ConfigLastReload.Set(float64(time.Now().Unix()))
+	logging.Sugar.Infow("Config reloaded",
+		"accrualWorkers", cfg.AccrualWorkers,
+		"accrualPollInterval", cfg.AccrualPollInterval,
+		"accrualRateLimit", cfg.AccrualRateLimit,
+		"logLevel", cfg.LogLevel,
+	)
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP and, if a config file is used,
+// whenever its modification time changes. It returns when ctx is done.
+func (r *Reloader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	cfg := r.Current()
+	var tick <-chan time.Time
+	var modTime time.Time
+	if cfg.ConfigFile != "" && cfg.ConfigWatchInterval > 0 {
+		modTime, _ = fileModTime(cfg.ConfigFile)
+		ticker := time.NewTicker(cfg.ConfigWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			logging.Sugar.Infow("SIGHUP received, reloading config")
+			r.Reload()
+		case <-tick:
+			mt, err := fileModTime(cfg.ConfigFile)
+			if err != nil {
+				logging.Sugar.Warnw("Unable to stat config file", "file", cfg.ConfigFile, "error", err)
+				continue
+			}
+			if mt.Equal(modTime) {
+				continue
+			}
+			modTime = mt
+			logging.Sugar.Infow("Config file changed, reloading config", "file", cfg.ConfigFile)
+			r.Reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func fileModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to stat %s: %w", path, err)
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/campaigns"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrorCampaignNotFound = errors.New("campaign not found")
+
+func CreateCampaignsTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateCampaignsTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS campaigns (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		starts_at TIMESTAMPTZ NOT NULL,
+		ends_at TIMESTAMPTZ NOT NULL,
+		status TEXT NOT NULL DEFAULT 'ACTIVE',
+		eligibility JSONB NOT NULL DEFAULT '{}',
+		bonus JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS campaign_bonuses (
+		id SERIAL PRIMARY KEY,
+		campaign_id INT REFERENCES campaigns(id) ON DELETE CASCADE,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		merchant_id INT NOT NULL REFERENCES merchants(id),
+		order_number TEXT NOT NULL,
+		amount NUMERIC(10, 2) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	ALTER TABLE campaign_bonuses ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants(id);
+	UPDATE campaign_bonuses SET merchant_id = (SELECT id FROM merchants WHERE code = 'default') WHERE merchant_id IS NULL;
+	ALTER TABLE campaign_bonuses ALTER COLUMN merchant_id SET NOT NULL;
+	ALTER TABLE campaign_bonuses DROP CONSTRAINT IF EXISTS campaign_bonuses_campaign_id_order_number_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_campaign_bonuses_order ON campaign_bonuses (campaign_id, merchant_id, order_number);`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+func CreateCampaign(ctx context.Context, db *pgxpool.Pool, req *models.CampaignRequest) (*models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "database.CreateCampaign")
+	defer span.End()
+
+	query := `INSERT INTO campaigns (name, starts_at, ends_at, eligibility, bonus)
+			  VALUES ($1, $2, $3, $4, $5)
+			  RETURNING id, name, starts_at, ends_at, status, eligibility, bonus, created_at`
+
+	row := db.QueryRow(ctx, query, req.Name, req.StartsAt, req.EndsAt, req.Eligibility, req.Bonus)
+	return scanCampaign(row)
+}
+
+func GetCampaign(ctx context.Context, db *pgxpool.Pool, id int) (*models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "database.GetCampaign")
+	defer span.End()
+
+	query := `SELECT id, name, starts_at, ends_at, status, eligibility, bonus, created_at
+			  FROM campaigns WHERE id = $1`
+
+	campaign, err := scanCampaign(db.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, ErrorCampaignNotFound
+	}
+	return campaign, err
+}
+
+func SetCampaignStatus(ctx context.Context, db *pgxpool.Pool, id int, status string) (*models.Campaign, error) {
+	ctx, span := tracing.Start(ctx, "database.SetCampaignStatus")
+	defer span.End()
+
+	query := `UPDATE campaigns SET status = $1 WHERE id = $2
+			  RETURNING id, name, starts_at, ends_at, status, eligibility, bonus, created_at`
+
+	campaign, err := scanCampaign(db.QueryRow(ctx, query, status, id))
+	if err == pgx.ErrNoRows {
+		return nil, ErrorCampaignNotFound
+	}
+	return campaign, err
+}
+
+// GetProcessedOrderFacts returns orders processed in [from, to) along with
+// whether each one was the first processed order of its user.
+func GetProcessedOrderFacts(ctx context.Context, db *pgxpool.Pool, from, to time.Time) ([]models.OrderFacts, error) {
+	ctx, span := tracing.Start(ctx, "database.GetProcessedOrderFacts")
+	defer span.End()
+
+	query := `
+		SELECT user_id, merchant_id, merchant, order_number, accrual, processed_at, first_order FROM (
+			SELECT o.user_id, o.merchant_id, m.code AS merchant, o.order_number, COALESCE(o.accrual, 0) AS accrual,
+				COALESCE(o.processed_at, o.uploaded_at) AS processed_at,
+				ROW_NUMBER() OVER (PARTITION BY o.user_id ORDER BY COALESCE(o.processed_at, o.uploaded_at), o.id) = 1 AS first_order
+			FROM orders o JOIN merchants m ON m.id = o.merchant_id
+			WHERE o.status = 'PROCESSED'
+		) processed
+		WHERE processed_at >= $1 AND processed_at < $2
+		ORDER BY processed_at`
+
+	rows, err := db.Query(ctx, query, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facts []models.OrderFacts
+	for rows.Next() {
+		var f models.OrderFacts
+		err := rows.Scan(&f.UserID, &f.MerchantID, &f.Merchant, &f.OrderNumber, &f.Accrual, &f.ProcessedAt, &f.FirstOrder)
+		if err != nil {
+			return nil, err
+		}
+		facts = append(facts, f)
+	}
+	return facts, rows.Err()
+}
+
+func scanCampaign(row pgx.Row) (*models.Campaign, error) {
+	var c models.Campaign
+	err := row.Scan(&c.ID, &c.Name, &c.StartsAt, &c.EndsAt, &c.Status, &c.Eligibility, &c.Bonus, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// applyCampaigns credits bonuses of every running campaign the processed order is eligible for.
+// Each bonus is recorded as a separate ledger line in campaign_bonuses.
+func applyCampaigns(ctx context.Context, tx pgx.Tx, facts models.OrderFacts) error {
+	queryCampaigns := `SELECT id, name, starts_at, ends_at, status, eligibility, bonus, created_at
+					   FROM campaigns
+					   WHERE status = 'ACTIVE' AND starts_at <= CURRENT_TIMESTAMP AND ends_at > CURRENT_TIMESTAMP
+					   ORDER BY id`
+
+	rows, err := tx.Query(ctx, queryCampaigns)
+	if err != nil {
+		return err
+	}
+	var running []*models.Campaign
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		running = append(running, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(running) == 0 {
+		return nil
+	}
+
+	for _, c := range running {
+		bonus := campaigns.Evaluate(c, facts)
+		if bonus <= 0 {
+			continue
+		}
+
+		queryInsBonus := `INSERT INTO campaign_bonuses (campaign_id, user_id, merchant_id, order_number, amount)
+						  VALUES ($1, $2, $3, $4, $5)
+						  ON CONFLICT (campaign_id, merchant_id, order_number) DO NOTHING`
+		tag, err := tx.Exec(ctx, queryInsBonus, c.ID, facts.UserID, facts.MerchantID, facts.OrderNumber, bonus)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		queryUpdBalance := `UPDATE users
+							SET balance = balance + $1
+							WHERE id = $2`
+		_, err = tx.Exec(ctx, queryUpdBalance, bonus, facts.UserID)
+		if err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Infow("Campaign bonus credited", "campaignID", c.ID, "merchant", facts.Merchant, "orderNumber", facts.OrderNumber, "bonus", bonus)
+	}
+	return nil
+}
@@ -4,22 +4,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
 	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/KirillZiborov/go-loyalty-program/internal/utils"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func CreateUsersTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateUsersTable")
+	defer span.End()
+
 	query := `
     CREATE TABLE IF NOT EXISTS users (
         id SERIAL PRIMARY KEY,
         login TEXT UNIQUE NOT NULL,
 		password TEXT NOT NULL,
 		balance NUMERIC (10, 2) DEFAULT 0,
-		withdrawn NUMERIC (10, 2) DEFAULT 0
+		withdrawn NUMERIC (10, 2) DEFAULT 0,
+		referral_code TEXT UNIQUE,
+		referred_by INT REFERENCES users(id) ON DELETE SET NULL,
+		signup_ip TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
     );
 	CREATE UNIQUE INDEX IF NOT EXISTS idx_login ON users (login);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code TEXT UNIQUE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS referred_by INT REFERENCES users(id) ON DELETE SET NULL;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS signup_ip TEXT;
+	UPDATE users SET referral_code = upper(substr(md5(random()::text || id::text), 1, 8)) WHERE referral_code IS NULL;
     `
 	_, err := db.Exec(ctx, query)
 	if err != nil {
@@ -29,15 +47,27 @@ func CreateUsersTable(ctx context.Context, db *pgxpool.Pool) error {
 }
 
 func CreateOrdersTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateOrdersTable")
+	defer span.End()
+
 	query := `
     CREATE TABLE IF NOT EXISTS orders (
     	id SERIAL PRIMARY KEY,
-    	order_number TEXT UNIQUE NOT NULL,
+		merchant_id INT NOT NULL REFERENCES merchants(id),
+    	order_number TEXT NOT NULL,
     	user_id INT REFERENCES users(id) ON DELETE CASCADE,
     	status TEXT NOT NULL DEFAULT 'NEW',
 		accrual NUMERIC(10, 2) DEFAULT NULL,
-    	uploaded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);`
+    	uploaded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		processed_at TIMESTAMP DEFAULT NULL
+	);
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP DEFAULT NULL;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants(id);
+	UPDATE orders SET merchant_id = (SELECT id FROM merchants WHERE code = 'default') WHERE merchant_id IS NULL;
+	ALTER TABLE orders ALTER COLUMN merchant_id SET NOT NULL;
+	ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_order_number_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_merchant_number ON orders (merchant_id, order_number);
+	CREATE INDEX IF NOT EXISTS idx_orders_user_uploaded ON orders (user_id, uploaded_at DESC, id DESC);`
 	_, err := db.Exec(ctx, query)
 	if err != nil {
 		return fmt.Errorf("unable to create table: %w", err)
@@ -46,14 +76,32 @@ func CreateOrdersTable(ctx context.Context, db *pgxpool.Pool) error {
 }
 
 func CreateWithdrawalsTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateWithdrawalsTable")
+	defer span.End()
+
 	query := `
     CREATE TABLE IF NOT EXISTS withdrawals (
 		id SERIAL PRIMARY KEY,
 		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		merchant_id INT NOT NULL REFERENCES merchants(id),
 		order_number TEXT NOT NULL,
 		amount NUMERIC(10, 2) NOT NULL,
+		order_value NUMERIC(10, 2) DEFAULT NULL,
+		status TEXT NOT NULL DEFAULT 'CONFIRMED',
+		expires_at TIMESTAMP DEFAULT NULL,
+		resolved_at TIMESTAMP DEFAULT NULL,
 		withdrawn_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);`
+	);
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS order_value NUMERIC(10, 2) DEFAULT NULL;
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'CONFIRMED';
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP DEFAULT NULL;
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP DEFAULT NULL;
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants(id);
+	UPDATE withdrawals SET merchant_id = (SELECT id FROM merchants WHERE code = 'default') WHERE merchant_id IS NULL;
+	ALTER TABLE withdrawals ALTER COLUMN merchant_id SET NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_withdrawals_pending ON withdrawals (expires_at) WHERE status = 'PENDING';
+	CREATE INDEX IF NOT EXISTS idx_withdrawals_user_withdrawn ON withdrawals (user_id, withdrawn_at DESC, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_withdrawals_merchant_order ON withdrawals (merchant_id, order_number);`
 	_, err := db.Exec(ctx, query)
 	if err != nil {
 		return fmt.Errorf("unable to create table: %w", err)
@@ -64,15 +112,46 @@ func CreateWithdrawalsTable(ctx context.Context, db *pgxpool.Pool) error {
 var ErrorDuplicate = errors.New("duplicate entry: user already exists")
 var ErrorInsufficientFunds = errors.New("insufficient funds")
 
-func CreateUser(ctx context.Context, db *pgxpool.Pool, user *models.User) (int, error) {
-	query := `INSERT INTO users (login, password) 
-			  VALUES ($1, $2)
+// CreateUser stores a new user with a freshly generated referral code.
+// If user.ReferralCode is set, it must belong to an existing user who becomes the referrer.
+// With rejectSameIP a code used from the address its owner signed up from is refused
+// as a self-referral.
+func CreateUser(ctx context.Context, db *pgxpool.Pool, user *models.User, rejectSameIP bool) (int, error) {
+	ctx, span := tracing.Start(ctx, "database.CreateUser")
+	defer span.End()
+
+	var referrerID *int
+	if user.ReferralCode != "" {
+		var id int
+		var signupIP *string
+		queryReferrer := `SELECT id, signup_ip FROM users WHERE referral_code = $1`
+		err := db.QueryRow(ctx, queryReferrer, strings.ToUpper(user.ReferralCode)).Scan(&id, &signupIP)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return 0, ErrorInvalidReferralCode
+			}
+			return 0, err
+		}
+		if rejectSameIP && user.SignupIP != "" && signupIP != nil && *signupIP == user.SignupIP {
+			logging.FromContext(ctx).Infow("Registration rejected: referral code used from the referrer's address", "referrerID", id)
+			return 0, ErrorSelfReferral
+		}
+		referrerID = &id
+	}
+
+	code, err := utils.GenerateReferralCode()
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO users (login, password, referral_code, referred_by, signup_ip) 
+			  VALUES ($1, $2, $3, $4, NULLIF($5, ''))
 			  ON CONFLICT (login) DO NOTHING
 			  RETURNING id
 			  `
 
 	var userID int
-	err := db.QueryRow(ctx, query, user.Login, user.Password).Scan(&userID)
+	err = db.QueryRow(ctx, query, user.Login, user.Password, code, referrerID, user.SignupIP).Scan(&userID)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -84,7 +163,23 @@ func CreateUser(ctx context.Context, db *pgxpool.Pool, user *models.User) (int,
 	return userID, nil
 }
 
+// ForgetSignupIPs clears the sign-up addresses of users registered before t
+// and returns how many were cleared.
+func ForgetSignupIPs(ctx context.Context, db *pgxpool.Pool, t time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "database.ForgetSignupIPs")
+	defer span.End()
+
+	tag, err := db.Exec(ctx, `UPDATE users SET signup_ip = NULL WHERE signup_ip IS NOT NULL AND created_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func GetUserByLogin(ctx context.Context, db *pgxpool.Pool, login string) (*models.User, error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserByLogin")
+	defer span.End()
+
 	var user models.User
 	query := `SELECT id, login, password FROM USERS WHERE login=$1`
 
@@ -98,19 +193,87 @@ func GetUserByLogin(ctx context.Context, db *pgxpool.Pool, login string) (*model
 	return &user, nil
 }
 
-func AddOrder(ctx context.Context, db *pgxpool.Pool, userID int, orderNumber string) error {
-	query := `INSERT INTO orders (order_number, user_id, status)
-			  VALUES ($1, $2, 'NEW')`
+func AddOrder(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, orderNumber string) error {
+	ctx, span := tracing.Start(ctx, "database.AddOrder")
+	defer span.End()
+
+	query := `INSERT INTO orders (order_number, user_id, merchant_id, status)
+			  VALUES ($1, $2, $3, 'NEW')`
 
-	_, err := db.Exec(ctx, query, orderNumber, userID)
+	_, err := db.Exec(ctx, query, orderNumber, userID, merchantID)
 	return err
 }
 
-func OrderExists(ctx context.Context, db *pgxpool.Pool, orderNumber string) (bool, int, error) {
-	query := `SELECT user_id FROM orders WHERE order_number = $1`
+// AddOrders inserts the merchant's order numbers in a single transaction, skipping those already uploaded.
+// It returns the numbers that were inserted and the owner of every number.
+func AddOrders(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, orderNumbers []string) (map[string]bool, map[string]int, error) {
+	ctx, span := tracing.Start(ctx, "database.AddOrders")
+	defer span.End()
+
+	accepted := make(map[string]bool)
+	owners := make(map[string]int)
+	if len(orderNumbers) == 0 {
+		return accepted, owners, nil
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	queryInsOrders := `INSERT INTO orders (order_number, user_id, merchant_id, status)
+					   SELECT n, $2, $3, 'NEW' FROM unnest($1::text[]) AS n
+					   ON CONFLICT (merchant_id, order_number) DO NOTHING
+					   RETURNING order_number`
+	rows, err := tx.Query(ctx, queryInsOrders, orderNumbers, userID, merchantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		accepted[number] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	queryOwners := `SELECT order_number, user_id FROM orders WHERE merchant_id = $1 AND order_number = ANY($2)`
+	rows, err = tx.Query(ctx, queryOwners, merchantID, orderNumbers)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var number string
+		var ownerID int
+		if err := rows.Scan(&number, &ownerID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		owners[number] = ownerID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	logging.FromContext(ctx).Debugw("Orders added", "submitted", len(orderNumbers), "accepted", len(accepted))
+	return accepted, owners, tx.Commit(ctx)
+}
+
+func OrderExists(ctx context.Context, db *pgxpool.Pool, merchantID int, orderNumber string) (bool, int, error) {
+	ctx, span := tracing.Start(ctx, "database.OrderExists")
+	defer span.End()
+
+	query := `SELECT user_id FROM orders WHERE merchant_id = $1 AND order_number = $2`
 
 	var userID int
-	err := db.QueryRow(ctx, query, orderNumber).Scan(&userID)
+	err := db.QueryRow(ctx, query, merchantID, orderNumber).Scan(&userID)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return false, 0, nil
@@ -120,27 +283,56 @@ func OrderExists(ctx context.Context, db *pgxpool.Pool, orderNumber string) (boo
 	return true, userID, nil
 }
 
-func GetOrdersByUserID(ctx context.Context, db *pgxpool.Pool, userID int) ([]models.Order, error) {
-	query := `SELECT order_number, status, accrual, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC`
-	rows, err := db.Query(ctx, query, userID)
+// GetOrdersByUserID returns a page of the user's orders of all merchants, newest first, and the cursor of the next page.
+func GetOrdersByUserID(ctx context.Context, db *pgxpool.Pool, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+	ctx, span := tracing.Start(ctx, "database.GetOrdersByUserID")
+	defer span.End()
+
+	query := `SELECT o.id, o.order_number, o.status, o.accrual, o.uploaded_at,
+				m.id, m.code, m.name, m.logo_url, m.color
+			  FROM orders o JOIN merchants m ON m.id = o.merchant_id
+			  WHERE o.user_id = $1
+				AND ($2::timestamp IS NULL OR (o.uploaded_at, o.id) < ($2::timestamp, $3))
+				AND ($4::text[] IS NULL OR o.status = ANY($4))
+				AND ($5::timestamp IS NULL OR o.uploaded_at >= $5)
+				AND ($6::timestamp IS NULL OR o.uploaded_at < $6)
+			  ORDER BY o.uploaded_at DESC, o.id DESC
+			  LIMIT $7`
+
+	afterTime, afterID := cursorArgs(params.After)
+	rows, err := db.Query(ctx, query, userID, afterTime, afterID, statusArg(params.Statuses), params.From, params.To, params.Limit+1)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.OrderNumber, &order.Status, &order.Accrual, &order.UploadedAt)
+		err := rows.Scan(&order.ID, &order.OrderNumber, &order.Status, &order.Accrual, &order.UploadedAt,
+			&order.MerchantID, &order.Merchant.Code, &order.Merchant.Name, &order.Merchant.LogoURL, &order.Merchant.Color)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		orders = append(orders, order)
 	}
-	return orders, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(orders) > params.Limit {
+		orders = orders[:params.Limit]
+		last := orders[len(orders)-1]
+		return orders, &pagination.Cursor{Time: last.UploadedAt, ID: last.ID}, nil
+	}
+	return orders, nil, nil
 }
 
+// GetUserBalance returns the user's balance, which is shared by all merchants.
 func GetUserBalance(ctx context.Context, db *pgxpool.Pool, userID int) (*models.Balance, error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserBalance")
+	defer span.End()
+
 	query := `SELECT balance, withdrawn FROM users WHERE id = $1`
 
 	var balance models.Balance
@@ -151,71 +343,84 @@ func GetUserBalance(ctx context.Context, db *pgxpool.Pool, userID int) (*models.
 	return &balance, nil
 }
 
-func WithdrawBalance(ctx context.Context, db *pgxpool.Pool, userID int, amount float32, orderNumber string) error {
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	var currentBalance float32
-	queryBalance := `SELECT balance FROM users WHERE id = $1`
-	err = tx.QueryRow(ctx, queryBalance, userID).Scan(&currentBalance)
-	if err != nil {
-		return err
-	}
-
-	if currentBalance < amount {
-		return ErrorInsufficientFunds
-	}
-
-	queryUpdBalance := `UPDATE users 
-						SET balance = balance - $1, withdrawn = withdrawn + $1
-						WHERE id = $2`
-	_, err = tx.Exec(ctx, queryUpdBalance, amount, userID)
-	if err != nil {
-		return err
-	}
+// WithdrawBalance spends amount points on the merchant's order. orderValue is recorded when known.
+// If oncePerOrder is set, ErrorOrderRedeemed is returned for orders that already have a withdrawal.
+// A non-empty quote spends the points held by that quote instead, see CreateQuote.
+func WithdrawBalance(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error {
+	ctx, span := tracing.Start(ctx, "database.WithdrawBalance")
+	defer span.End()
 
-	queryInsWithdraw := `INSERT INTO withdrawals (user_id, order_number, amount) 
-						 VALUES ($1, $2, $3)`
-	_, err = tx.Exec(ctx, queryInsWithdraw, userID, orderNumber, amount)
-	if err != nil {
-		return err
-	}
-	return tx.Commit(ctx)
+	_, err := withdraw(ctx, db, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, 0, quote)
+	return err
 }
 
-func GetUserWithdrawals(ctx context.Context, db *pgxpool.Pool, userID int) ([]models.Withdrawal, error) {
+// GetUserWithdrawals returns a page of the user's withdrawals, newest first, and the cursor of the next page.
+func GetUserWithdrawals(ctx context.Context, db *pgxpool.Pool, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserWithdrawals")
+	defer span.End()
+
 	query := `
-        SELECT order_number, amount, withdrawn_at 
+        SELECT id, order_number, amount, order_value, status, expires_at, withdrawn_at 
         FROM withdrawals 
         WHERE user_id = $1 
-        ORDER BY withdrawn_at DESC
+			AND ($2::timestamp IS NULL OR (withdrawn_at, id) < ($2::timestamp, $3))
+			AND ($4::text[] IS NULL OR status = ANY($4))
+			AND ($5::timestamp IS NULL OR withdrawn_at >= $5)
+			AND ($6::timestamp IS NULL OR withdrawn_at < $6)
+        ORDER BY withdrawn_at DESC, id DESC
+		LIMIT $7
     `
-	rows, err := db.Query(ctx, query, userID)
+	afterTime, afterID := cursorArgs(params.After)
+	rows, err := db.Query(ctx, query, userID, afterTime, afterID, statusArg(params.Statuses), params.From, params.To, params.Limit+1)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var withdrawals []models.Withdrawal
 	for rows.Next() {
 		var withdrawal models.Withdrawal
-		err := rows.Scan(&withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.ProcessedAt)
+		err := rows.Scan(&withdrawal.ID, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.OrderValue,
+			&withdrawal.Status, &withdrawal.ExpiresAt, &withdrawal.ProcessedAt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		withdrawals = append(withdrawals, withdrawal)
 	}
-	return withdrawals, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(withdrawals) > params.Limit {
+		withdrawals = withdrawals[:params.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		return withdrawals, &pagination.Cursor{Time: last.ProcessedAt, ID: last.ID}, nil
+	}
+	return withdrawals, nil, nil
+}
+
+func cursorArgs(c *pagination.Cursor) (*time.Time, int) {
+	if c == nil {
+		return nil, 0
+	}
+	return &c.Time, c.ID
+}
+
+func statusArg(statuses []string) []string {
+	if len(statuses) == 0 {
+		return nil
+	}
+	return statuses
 }
 
 func GetPendingOrders(ctx context.Context, db *pgxpool.Pool) ([]models.Order, error) {
+	ctx, span := tracing.Start(ctx, "database.GetPendingOrders")
+	defer span.End()
+
 	query := `
-        SELECT user_id, order_number, status 
-        FROM orders 
-        WHERE status = 'NEW' OR status = 'PROCESSING'`
+        SELECT o.user_id, o.merchant_id, o.order_number, o.status, m.accrual_address
+        FROM orders o JOIN merchants m ON m.id = o.merchant_id
+        WHERE o.status = 'NEW' OR o.status = 'PROCESSING'`
 
 	rows, err := db.Query(ctx, query)
 	if err != nil {
@@ -226,7 +431,7 @@ func GetPendingOrders(ctx context.Context, db *pgxpool.Pool) ([]models.Order, er
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.UserID, &order.OrderNumber, &order.Status)
+		err := rows.Scan(&order.UserID, &order.MerchantID, &order.OrderNumber, &order.Status, &order.AccrualAddress)
 		if err != nil {
 			return nil, err
 		}
@@ -235,32 +440,113 @@ func GetPendingOrders(ctx context.Context, db *pgxpool.Pool) ([]models.Order, er
 	return orders, nil
 }
 
-func UpdateOrder(ctx context.Context, db *pgxpool.Pool, orderNumber, status string, accrual float32, userID int) error {
+// UpdateOrder records the status reported by the accrual system. Processed orders
+// are final, so a repeated PROCESSED report changes nothing and the accrual,
+// campaign bonuses and referral reward are credited once. It reports whether
+// the order changed.
+func UpdateOrder(ctx context.Context, db *pgxpool.Pool, merchantID int, orderNumber, status string, accrual float32, userID int, referral models.ReferralProgram) (bool, error) {
+	ctx, span := tracing.Start(ctx, "database.UpdateOrder")
+	defer span.End()
+
 	tx, err := db.Begin(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback(ctx)
 
-	queryOrders := `UPDATE orders
-					SET status = $1, accrual = $2
-					WHERE order_number = $3`
+	logging.FromContext(ctx).Debugw("Updating order", "merchantID", merchantID, "orderNumber", orderNumber, "status", status, "accrual", accrual, "user_id", userID)
+
+	queryOrders := `WITH previous AS (
+						SELECT status FROM orders WHERE merchant_id = $3 AND order_number = $4 FOR UPDATE
+					)
+					UPDATE orders
+					SET status = $1, accrual = $2,
+						processed_at = CASE WHEN $1 = 'PROCESSED' THEN CURRENT_TIMESTAMP ELSE processed_at END
+					FROM previous, merchants m
+					WHERE orders.merchant_id = $3 AND orders.order_number = $4 AND m.id = orders.merchant_id
+						AND previous.status <> 'PROCESSED'
+					RETURNING previous.status, orders.uploaded_at, m.code, m.name, m.logo_url, m.color`
+
+	var previousStatus string
+	var uploadedAt time.Time
+	var merchant models.MerchantBrand
+	err = tx.QueryRow(ctx, queryOrders, status, accrual, merchantID, orderNumber).Scan(&previousStatus, &uploadedAt,
+		&merchant.Code, &merchant.Name, &merchant.LogoURL, &merchant.Color)
+	if err == pgx.ErrNoRows {
+		logging.FromContext(ctx).Debugw("Order already processed", "merchantID", merchantID, "orderNumber", orderNumber)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update orders: %w", err)
+	}
+	if previousStatus == status {
+		return false, tx.Commit(ctx)
+	}
 
-	_, err = tx.Exec(ctx, queryOrders, status, accrual, orderNumber)
+	event := models.OrderResponse{OrderNumber: orderNumber, Status: status, UploadedAt: uploadedAt.Format(time.RFC3339), Merchant: &merchant}
+	if status == models.OrderStatusProcessed {
+		event.Accrual = accrual
+	}
+	err = addEvent(ctx, tx, userID, models.EventOrder, event)
 	if err != nil {
-		return fmt.Errorf("failed to update orders: %w", err)
+		return false, fmt.Errorf("failed to add order event: %w", err)
 	}
 
-	if status == "PROCESSED" && accrual > 0 {
-		queryUpdBalance := `UPDATE users 
-							SET balance = balance + $1
-							WHERE id = $2`
-		_, err = tx.Exec(ctx, queryUpdBalance, accrual, userID)
+	if status == models.OrderStatusProcessed {
+		// Lock the user first so that of two orders processed at once only one
+		// counts as the first.
+		var lockedID int
+		queryLock := `SELECT id FROM users WHERE id = $1 FOR UPDATE`
+		err = tx.QueryRow(ctx, queryLock, userID).Scan(&lockedID)
 		if err != nil {
-			return fmt.Errorf("failed to update user balance: %w", err)
+			return false, fmt.Errorf("failed to lock user: %w", err)
 		}
-	}
 
-	return tx.Commit(ctx)
+		if accrual > 0 {
+			queryUpdBalance := `UPDATE users 
+								SET balance = balance + $1
+								WHERE id = $2`
+			_, err = tx.Exec(ctx, queryUpdBalance, accrual, userID)
+			if err != nil {
+				return false, fmt.Errorf("failed to update user balance: %w", err)
+			}
+		}
+
+		var previous int
+		queryPrevious := `SELECT COUNT(*) FROM orders
+						  WHERE user_id = $1 AND status = 'PROCESSED' AND NOT (merchant_id = $2 AND order_number = $3)`
+		err = tx.QueryRow(ctx, queryPrevious, userID, merchantID, orderNumber).Scan(&previous)
+		if err != nil {
+			return false, fmt.Errorf("failed to count processed orders: %w", err)
+		}
+
+		facts := models.OrderFacts{
+			UserID:      userID,
+			MerchantID:  merchantID,
+			Merchant:    merchant.Code,
+			OrderNumber: orderNumber,
+			Accrual:     accrual,
+			FirstOrder:  previous == 0,
+			ProcessedAt: time.Now(),
+		}
+
+		err = applyCampaigns(ctx, tx, facts)
+		if err != nil {
+			return false, fmt.Errorf("failed to apply campaigns: %w", err)
+		}
+
+		if facts.FirstOrder {
+			err = applyReferralReward(ctx, tx, facts, referral)
+			if err != nil {
+				return false, fmt.Errorf("failed to apply referral reward: %w", err)
+			}
+		}
+
+		err = addBalanceEvent(ctx, tx, userID)
+		if err != nil {
+			return false, fmt.Errorf("failed to add balance event: %w", err)
+		}
+	}
 
+	return true, tx.Commit(ctx)
 }
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDB connects to TEST_DATABASE_URI and creates the tables in a schema of
+// their own, dropped when the test ends. Without the variable the test is skipped.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	admin, err := pgx.Connect(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		admin.Close(ctx)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+		admin.Close(ctx)
+	})
+
+	config, err := pgxpool.ParseConfig(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	db, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	for _, create := range []func(context.Context, *pgxpool.Pool) error{
+		CreateUsersTable, CreateMerchantsTable, CreateOrdersTable, CreateWithdrawalsTable,
+		CreateTransfersTable, CreateReferralRewardsTable, CreateCampaignsTable, CreateQuotesTable, CreateEventsTable,
+	} {
+		if err := create(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+var users atomic.Int64
+
+// newUser creates a user holding balance points and returns its id.
+func newUser(t *testing.T, db *pgxpool.Pool, balance float32) int {
+	t.Helper()
+	ctx := context.Background()
+	login := fmt.Sprintf("user%d", users.Add(1))
+	userID, err := CreateUser(ctx, db, &models.User{Login: login, Password: "hash"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `UPDATE users SET balance = $1 WHERE id = $2`, balance, userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// merchantID returns the id of the merchant with code, creating it if needed.
+func merchantID(t *testing.T, db *pgxpool.Pool, code string) int {
+	t.Helper()
+	ctx := context.Background()
+	m, err := GetMerchantByCode(ctx, db, code)
+	if err == ErrorMerchantNotFound {
+		m, err = CreateMerchant(ctx, db, &models.MerchantRequest{Code: code, Name: code})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.ID
+}
+
+// checkBalance fails the test unless the user has current points and withdrew withdrawn.
+func checkBalance(t *testing.T, db *pgxpool.Pool, userID int, current, withdrawn float32) {
+	t.Helper()
+	balance, err := GetUserBalance(context.Background(), db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != current || balance.Withdrawn != withdrawn {
+		t.Fatalf("balance = %v current, %v withdrawn, want %v, %v", balance.Current, balance.Withdrawn, current, withdrawn)
+	}
+}
+
+// expireNow moves the expiry of the table rows with column equal to value into the past.
+func expireNow(t *testing.T, db *pgxpool.Pool, table, column string, value any) {
+	t.Helper()
+	query := `UPDATE ` + table + ` SET expires_at = CURRENT_TIMESTAMP - interval '1 second' WHERE ` + column + ` = $1`
+	if _, err := db.Exec(context.Background(), query, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkExpireHolds fails the test unless ExpireHolds releases the given numbers of withdrawals and quotes.
+func checkExpireHolds(t *testing.T, db *pgxpool.Pool, withdrawals, quotes int) {
+	t.Helper()
+	gotWithdrawals, gotQuotes, err := ExpireHolds(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotWithdrawals != withdrawals || gotQuotes != quotes {
+		t.Fatalf("ExpireHolds released %d withdrawals, %d quotes, want %d, %d", gotWithdrawals, gotQuotes, withdrawals, quotes)
+	}
+}
+
+func TestUpdateOrderFirstOrderOnce(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	merchant := merchantID(t, db, DefaultMerchant)
+	userID := newUser(t, db, 0)
+	_, err := CreateCampaign(ctx, db, &models.CampaignRequest{
+		Name:        "welcome",
+		StartsAt:    time.Now().Add(-time.Hour),
+		EndsAt:      time.Now().Add(time.Hour),
+		Eligibility: models.CampaignEligibility{FirstOrderOnly: true},
+		Bonus:       models.CampaignBonus{Fixed: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := []string{"12345678903", "9278923470"}
+	for _, number := range numbers {
+		if err := AddOrder(ctx, db, userID, merchant, number); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make(chan error, len(numbers))
+	for _, number := range numbers {
+		go func() {
+			_, err := UpdateOrder(ctx, db, merchant, number, models.OrderStatusProcessed, 0, userID, models.ReferralProgram{})
+			errs <- err
+		}()
+	}
+	for range numbers {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBalance(t, db, userID, 10, 0)
+}
+
+func TestForgetSignupIPs(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	old, err := CreateUser(ctx, db, &models.User{Login: "old", Password: "hash", SignupIP: "192.0.2.1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `UPDATE users SET created_at = created_at - interval '1 day' WHERE id = $1`, old); err != nil {
+		t.Fatal(err)
+	}
+	recent, err := CreateUser(ctx, db, &models.User{Login: "recent", Password: "hash", SignupIP: "192.0.2.2"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleared, err := ForgetSignupIPs(ctx, db, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 1 {
+		t.Errorf("ForgetSignupIPs cleared %d addresses, want 1", cleared)
+	}
+	for userID, want := range map[int]string{old: "", recent: "192.0.2.2"} {
+		var ip string
+		if err := db.QueryRow(ctx, `SELECT COALESCE(signup_ip, '') FROM users WHERE id = $1`, userID).Scan(&ip); err != nil {
+			t.Fatal(err)
+		}
+		if ip != want {
+			t.Errorf("user %d signup_ip = %q, want %q", userID, ip, want)
+		}
+	}
+}
+
+func TestGetUserWithdrawalsStatus(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+
+	if err := WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := ReserveWithdrawal(ctx, db, userID, def, 10, "2377225624", nil, false, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		statuses []string
+		want     int
+	}{
+		{want: 2},
+		{statuses: []string{models.WithdrawalStatusPending}, want: 1},
+		{statuses: []string{models.WithdrawalStatusPending, models.WithdrawalStatusConfirmed}, want: 2},
+		{statuses: []string{models.WithdrawalStatusCancelled}, want: 0},
+	}
+	for _, tt := range tests {
+		withdrawals, _, err := GetUserWithdrawals(ctx, db, userID, &pagination.Params{Limit: 10, Statuses: tt.statuses})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(withdrawals) != tt.want {
+			t.Errorf("withdrawals with statuses %v = %d, want %d", tt.statuses, len(withdrawals), tt.want)
+		}
+		if len(tt.statuses) == 1 && tt.want == 1 && withdrawals[0].ID != pending.ID {
+			t.Errorf("pending withdrawal id = %d, want %d", withdrawals[0].ID, pending.ID)
+		}
+	}
+}
+
+// TestBalanceSharedByMerchants checks that points earned with one merchant can
+// be spent with another and that the order listing covers all merchants.
+func TestBalanceSharedByMerchants(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 0)
+	def := merchantID(t, db, DefaultMerchant)
+	other := merchantID(t, db, "other")
+
+	for _, m := range []int{def, other} {
+		if err := AddOrder(ctx, db, userID, m, "12345678903"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := UpdateOrder(ctx, db, def, "12345678903", models.OrderStatusProcessed, 50, userID, models.ReferralProgram{}); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(t, db, userID, 50, 0)
+
+	if err := WithdrawBalance(ctx, db, userID, other, 30, "2377225624", nil, false, ""); err != nil {
+		t.Fatalf("withdrawal with another merchant: %v", err)
+	}
+	checkBalance(t, db, userID, 20, 30)
+
+	orders, _, err := GetOrdersByUserID(ctx, db, userID, &pagination.Params{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merchants []string
+	for _, o := range orders {
+		merchants = append(merchants, o.Merchant.Code)
+	}
+	slices.Sort(merchants)
+	if !slices.Equal(merchants, []string{DefaultMerchant, "other"}) {
+		t.Errorf("orders listed for merchants %v, want %v", merchants, []string{DefaultMerchant, "other"})
+	}
+}
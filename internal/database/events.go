@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EventsChannel is the channel every stored event is announced on with pg_notify.
+const EventsChannel = "user_events"
+
+func CreateEventsTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateEventsTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS events (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		seq BIGINT NOT NULL,
+		type TEXT NOT NULL,
+		data JSONB NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS event_seq BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS seq BIGINT;
+	UPDATE events SET seq = numbered.seq
+	FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY id) AS seq FROM events) numbered
+	WHERE events.id = numbered.id AND events.seq IS NULL;
+	UPDATE users SET event_seq = last.seq
+	FROM (SELECT user_id, MAX(seq) AS seq FROM events GROUP BY user_id) last
+	WHERE users.id = last.user_id AND users.event_seq < last.seq;
+	ALTER TABLE events ALTER COLUMN seq SET NOT NULL;
+	DROP INDEX IF EXISTS idx_events_user;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_events_user_seq ON events (user_id, seq);
+	CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at);`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+// addEvent stores an event and announces it to listeners once tx commits.
+// The event id is the next number of the user's own sequence, taken under the
+// user's row lock, so that a user's events commit in id order.
+func addEvent(ctx context.Context, tx pgx.Tx, userID int, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	event := models.Event{UserID: userID, Type: eventType, Data: payload}
+	queryInsEvent := `WITH next AS (
+						UPDATE users SET event_seq = event_seq + 1 WHERE id = $1 RETURNING event_seq
+					  )
+					  INSERT INTO events (user_id, seq, type, data) SELECT $1, event_seq, $2, $3 FROM next
+					  RETURNING seq, created_at`
+	err = tx.QueryRow(ctx, queryInsEvent, userID, eventType, payload).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	notification, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, EventsChannel, string(notification))
+	return err
+}
+
+// addBalanceEvent stores the user's balance as of the end of tx.
+func addBalanceEvent(ctx context.Context, tx pgx.Tx, userID int) error {
+	var balance models.BalanceResponse
+	queryBalance := `SELECT balance, withdrawn FROM users WHERE id = $1`
+	err := tx.QueryRow(ctx, queryBalance, userID).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return err
+	}
+	return addEvent(ctx, tx, userID, models.EventBalance, balance)
+}
+
+// GetEventsAfter returns up to limit events of the user with ids greater than afterID, oldest first.
+// Ids are numbered per user.
+func GetEventsAfter(ctx context.Context, db *pgxpool.Pool, userID int, afterID int64, limit int) ([]models.Event, error) {
+	ctx, span := tracing.Start(ctx, "database.GetEventsAfter")
+	defer span.End()
+
+	query := `
+        SELECT seq, type, data, created_at
+        FROM events
+        WHERE user_id = $1 AND seq > $2
+        ORDER BY seq
+        LIMIT $3`
+	rows, err := db.Query(ctx, query, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		event := models.Event{UserID: userID}
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// DeleteEventsBefore removes events created before t and returns how many were removed.
+func DeleteEventsBefore(ctx context.Context, db *pgxpool.Pool, t time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "database.DeleteEventsBefore")
+	defer span.End()
+
+	tag, err := db.Exec(ctx, `DELETE FROM events WHERE created_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestEventsCommitInIDOrder(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 0)
+	otherID := newUser(t, db, 0)
+
+	first, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback(ctx)
+	if err := addBalanceEvent(ctx, first, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second event waits for the first transaction, which holds the user's next id.
+	done := make(chan error, 1)
+	go func() {
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer tx.Rollback(ctx)
+		if err := addBalanceEvent(ctx, tx, userID); err != nil {
+			done <- err
+			return
+		}
+		done <- tx.Commit(ctx)
+	}()
+	if err := addEventTx(ctx, db, otherID); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		userID  int
+		afterID int64
+		want    []int64
+	}{
+		{userID: userID, want: []int64{1, 2}},
+		{userID: userID, afterID: 1, want: []int64{2}},
+		{userID: otherID, want: []int64{1}},
+	} {
+		events, err := GetEventsAfter(ctx, db, tt.userID, tt.afterID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("events of user %d after %d = %v, want %v", tt.userID, tt.afterID, ids, tt.want)
+		}
+	}
+}
+
+// addEventTx stores an order event for the user in a transaction of its own.
+func addEventTx(ctx context.Context, db *pgxpool.Pool, userID int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := addEvent(ctx, tx, userID, models.EventOrder, models.OrderResponse{}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
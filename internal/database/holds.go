@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ExpireHolds returns the points of pending withdrawals and active quotes past
+// their expiry to the users and reports how many of each were released.
+func ExpireHolds(ctx context.Context, db *pgxpool.Pool) (withdrawals, quotes int, err error) {
+	ctx, span := tracing.Start(ctx, "database.ExpireHolds")
+	defer span.End()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var users []int
+	withdrawals, users, err = expireHolds(ctx, tx, "withdrawals", models.WithdrawalStatusPending, users)
+	if err != nil {
+		return 0, 0, err
+	}
+	quotes, users, err = expireHolds(ctx, tx, "quotes", models.QuoteStatusActive, users)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, userID := range users {
+		if err := addBalanceEvent(ctx, tx, userID); err != nil {
+			return 0, 0, err
+		}
+	}
+	return withdrawals, quotes, tx.Commit(ctx)
+}
+
+// expireHolds marks the rows of table in the active status past their expiry
+// EXPIRED and credits their amounts back. It returns how many rows expired and
+// users extended with the users whose balance changed.
+func expireHolds(ctx context.Context, tx pgx.Tx, table, active string, users []int) (int, []int, error) {
+	query := `WITH expired AS (
+				UPDATE ` + table + ` SET status = 'EXPIRED', resolved_at = CURRENT_TIMESTAMP
+				WHERE status = $1 AND expires_at <= CURRENT_TIMESTAMP
+				RETURNING user_id, amount
+			  ), released AS (
+				SELECT user_id, SUM(amount) AS amount, COUNT(*) AS count FROM expired GROUP BY user_id
+			  )
+			  UPDATE users SET balance = balance + released.amount
+			  FROM released WHERE users.id = released.user_id
+			  RETURNING users.id, released.count`
+	rows, err := tx.Query(ctx, query, active)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		var userID, n int
+		if err := rows.Scan(&userID, &n); err != nil {
+			return 0, nil, err
+		}
+		if !slices.Contains(users, userID) {
+			users = append(users, userID)
+		}
+		count += n
+	}
+	return count, users, rows.Err()
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultMerchant owns orders submitted without a merchant and those uploaded
+// before merchants were introduced.
+const DefaultMerchant = "default"
+
+var ErrorMerchantNotFound = errors.New("merchant not found")
+var ErrorMerchantExists = errors.New("merchant already exists")
+
+func CreateMerchantsTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateMerchantsTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS merchants (
+		id SERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		accrual_address TEXT NOT NULL DEFAULT '',
+		logo_url TEXT NOT NULL DEFAULT '',
+		color TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO merchants (code, name) VALUES ('default', 'Gophermart') ON CONFLICT (code) DO NOTHING;`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+func CreateMerchant(ctx context.Context, db *pgxpool.Pool, req *models.MerchantRequest) (*models.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "database.CreateMerchant")
+	defer span.End()
+
+	query := `INSERT INTO merchants (code, name, accrual_address, logo_url, color)
+			  VALUES ($1, $2, $3, $4, $5)
+			  ON CONFLICT (code) DO NOTHING
+			  RETURNING id, code, name, accrual_address, logo_url, color, created_at`
+
+	merchant, err := scanMerchant(db.QueryRow(ctx, query, req.Code, req.Name, req.AccrualAddress, req.LogoURL, req.Color))
+	if err == pgx.ErrNoRows {
+		return nil, ErrorMerchantExists
+	}
+	return merchant, err
+}
+
+func GetMerchantByCode(ctx context.Context, db *pgxpool.Pool, code string) (*models.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "database.GetMerchantByCode")
+	defer span.End()
+
+	query := `SELECT id, code, name, accrual_address, logo_url, color, created_at
+			  FROM merchants WHERE code = $1`
+
+	merchant, err := scanMerchant(db.QueryRow(ctx, query, code))
+	if err == pgx.ErrNoRows {
+		return nil, ErrorMerchantNotFound
+	}
+	return merchant, err
+}
+
+func GetMerchants(ctx context.Context, db *pgxpool.Pool) ([]models.Merchant, error) {
+	ctx, span := tracing.Start(ctx, "database.GetMerchants")
+	defer span.End()
+
+	query := `SELECT id, code, name, accrual_address, logo_url, color, created_at
+			  FROM merchants ORDER BY code`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merchants []models.Merchant
+	for rows.Next() {
+		m, err := scanMerchant(rows)
+		if err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, *m)
+	}
+	return merchants, rows.Err()
+}
+
+func scanMerchant(row pgx.Row) (*models.Merchant, error) {
+	var m models.Merchant
+	err := row.Scan(&m.ID, &m.Code, &m.Name, &m.AccrualAddress, &m.LogoURL, &m.Color, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
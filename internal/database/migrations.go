@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tables lists every table created at startup.
+var Tables = []string{
+	"users",
+	"merchants",
+	"orders",
+	"withdrawals",
+	"transfers",
+	"referral_rewards",
+	"campaigns",
+	"campaign_bonuses",
+	"quotes",
+	"events",
+}
+
+// CheckMigrations returns an error naming the tables that are missing from the database.
+func CheckMigrations(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CheckMigrations")
+	defer span.End()
+
+	query := `SELECT t FROM unnest($1::text[]) AS t WHERE to_regclass(t) IS NULL`
+	rows, err := db.Query(ctx, query, Tables)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		missing = append(missing, table)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/KirillZiborov/go-loyalty-program/internal/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrorQuoteNotFound = errors.New("quote not found")
+var ErrorQuoteExpired = errors.New("quote expired or already used")
+var ErrorQuoteExceeded = errors.New("withdrawal exceeds the quoted amount")
+
+func CreateQuotesTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateQuotesTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS quotes (
+		id SERIAL PRIMARY KEY,
+		token TEXT UNIQUE NOT NULL,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		merchant_id INT NOT NULL REFERENCES merchants(id),
+		order_value NUMERIC(10, 2) NOT NULL,
+		amount NUMERIC(10, 2) NOT NULL,
+		status TEXT NOT NULL DEFAULT 'ACTIVE',
+		withdrawal_id INT REFERENCES withdrawals(id) ON DELETE SET NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP DEFAULT NULL
+	);
+	ALTER TABLE quotes ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP DEFAULT NULL;
+	ALTER TABLE quotes ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants(id);
+	UPDATE quotes SET merchant_id = (SELECT id FROM merchants WHERE code = 'default') WHERE merchant_id IS NULL;
+	ALTER TABLE quotes ALTER COLUMN merchant_id SET NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_quotes_active ON quotes (expires_at) WHERE status = 'ACTIVE';`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+// CreateQuote holds the points the user can redeem on an order of orderValue
+// with the merchant, at most maxAmount, for ttl. If that is less than minAmount
+// nothing is held and the quote has no token.
+func CreateQuote(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error) {
+	ctx, span := tracing.Start(ctx, "database.CreateQuote")
+	defer span.End()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var balance float32
+	queryBalance := `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, queryBalance, userID).Scan(&balance)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &models.Quote{OrderValue: orderValue, Redeemable: min(balance, maxAmount)}
+	if quote.Redeemable <= 0 || quote.Redeemable < minAmount {
+		quote.Redeemable = 0
+		return quote, nil
+	}
+
+	quote.Token, err = utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	queryUpdBalance := `UPDATE users SET balance = balance - $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryUpdBalance, quote.Redeemable, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	queryInsQuote := `INSERT INTO quotes (token, user_id, merchant_id, order_value, amount, expires_at)
+					  VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP + make_interval(secs => $6))
+					  RETURNING expires_at`
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, queryInsQuote, quote.Token, userID, merchantID, orderValue, quote.Redeemable, ttl.Seconds()).Scan(&expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	quote.ExpiresAt = &expiresAt
+
+	err = addBalanceEvent(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debugw("Quote created", "amount", quote.Redeemable, "orderValue", orderValue)
+	return quote, tx.Commit(ctx)
+}
+
+// claimQuote marks the user's active quote for the merchant used for a withdrawal
+// of amount and returns the points it held and the quoted order value.
+func claimQuote(ctx context.Context, tx pgx.Tx, userID, merchantID int, token string, amount float32) (float32, float32, error) {
+	var held, orderValue float32
+	var status string
+	var expired bool
+	query := `SELECT amount, order_value, status, expires_at <= CURRENT_TIMESTAMP FROM quotes
+			  WHERE token = $1 AND user_id = $2 AND merchant_id = $3 FOR UPDATE`
+	err := tx.QueryRow(ctx, query, token, userID, merchantID).Scan(&held, &orderValue, &status, &expired)
+	switch {
+	case err == pgx.ErrNoRows:
+		return 0, 0, ErrorQuoteNotFound
+	case err != nil:
+		return 0, 0, err
+	case status != models.QuoteStatusActive || expired:
+		return 0, 0, ErrorQuoteExpired
+	case amount > held:
+		return 0, 0, ErrorQuoteExceeded
+	}
+
+	queryUse := `UPDATE quotes SET status = 'USED', resolved_at = CURRENT_TIMESTAMP WHERE token = $1`
+	_, err = tx.Exec(ctx, queryUse, token)
+	if err != nil {
+		return 0, 0, err
+	}
+	return held, orderValue, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuoteClaim(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+	other := merchantID(t, db, "other")
+
+	quote, err := CreateQuote(ctx, db, userID, def, 200, 30, 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Token == "" || quote.Redeemable != 30 || quote.ExpiresAt == nil {
+		t.Fatalf("quote = %+v, want 30 points held with a token", quote)
+	}
+	checkBalance(t, db, userID, 70, 0)
+
+	tests := []struct {
+		name     string
+		merchant int
+		token    string
+		sum      float32
+		wantErr  error
+	}{
+		{name: "unknown token", merchant: def, token: "unknown", sum: 10, wantErr: ErrorQuoteNotFound},
+		{name: "other merchant", merchant: other, token: quote.Token, sum: 10, wantErr: ErrorQuoteNotFound},
+		{name: "above the quote", merchant: def, token: quote.Token, sum: 30.01, wantErr: ErrorQuoteExceeded},
+		{name: "within the quote", merchant: def, token: quote.Token, sum: 20},
+		{name: "used quote", merchant: def, token: quote.Token, sum: 5, wantErr: ErrorQuoteExpired},
+	}
+	for _, tt := range tests {
+		err := WithdrawBalance(ctx, db, userID, tt.merchant, tt.sum, "12345678903", nil, false, tt.token)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: WithdrawBalance = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// The unspent part of the quote returns to the balance.
+	checkBalance(t, db, userID, 80, 20)
+	// A used quote is not released again.
+	checkExpireHolds(t, db, 0, 0)
+}
+
+func TestQuoteBelowMinimum(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 3)
+
+	quote, err := CreateQuote(ctx, db, userID, merchantID(t, db, DefaultMerchant), 200, 30, 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Token != "" || quote.Redeemable != 0 {
+		t.Fatalf("quote = %+v, want nothing held below the minimum", quote)
+	}
+	checkBalance(t, db, userID, 3, 0)
+}
+
+func TestQuoteExpiry(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+
+	quote, err := CreateQuote(ctx, db, userID, def, 200, 30, 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpireHolds(t, db, 0, 0)
+	expireNow(t, db, "quotes", "token", quote.Token)
+
+	// The quote cannot be claimed once expired, even before it is released.
+	err = WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, false, quote.Token)
+	if !errors.Is(err, ErrorQuoteExpired) {
+		t.Fatalf("withdrawal with an expired quote = %v, want %v", err, ErrorQuoteExpired)
+	}
+	checkBalance(t, db, userID, 70, 0)
+
+	checkExpireHolds(t, db, 0, 1)
+	checkBalance(t, db, userID, 100, 0)
+	checkExpireHolds(t, db, 0, 0)
+
+	err = WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, false, quote.Token)
+	if !errors.Is(err, ErrorQuoteExpired) {
+		t.Fatalf("withdrawal with a released quote = %v, want %v", err, ErrorQuoteExpired)
+	}
+}
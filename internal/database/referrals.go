@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrorInvalidReferralCode = errors.New("invalid referral code")
+
+// ErrorSelfReferral is returned when a referral code is used from the address its owner signed up from.
+var ErrorSelfReferral = errors.New("self-referral is not allowed")
+
+func CreateReferralRewardsTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateReferralRewardsTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS referral_rewards (
+		id SERIAL PRIMARY KEY,
+		referrer_id INT REFERENCES users(id) ON DELETE CASCADE,
+		referred_id INT UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+		order_number TEXT NOT NULL,
+		amount NUMERIC(10, 2) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_users_referred_by ON users (referred_by);`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+// applyReferralReward credits the referrer of the user whose first order has just been processed.
+// The referrer row is locked so that concurrent rewards cannot exceed the per-referrer cap.
+func applyReferralReward(ctx context.Context, tx pgx.Tx, facts models.OrderFacts, program models.ReferralProgram) error {
+	if program.Bonus <= 0 {
+		return nil
+	}
+
+	var referrerID *int
+	queryReferrer := `SELECT referred_by FROM users WHERE id = $1`
+	err := tx.QueryRow(ctx, queryReferrer, facts.UserID).Scan(&referrerID)
+	if err != nil {
+		return err
+	}
+	if referrerID == nil || *referrerID == facts.UserID {
+		return nil
+	}
+
+	var lockedID int
+	queryLock := `SELECT id FROM users WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, queryLock, *referrerID).Scan(&lockedID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if program.Cap > 0 {
+		var rewarded int
+		queryRewarded := `SELECT COUNT(*) FROM referral_rewards WHERE referrer_id = $1`
+		err = tx.QueryRow(ctx, queryRewarded, *referrerID).Scan(&rewarded)
+		if err != nil {
+			return err
+		}
+		if rewarded >= program.Cap {
+			return nil
+		}
+	}
+
+	queryInsReward := `INSERT INTO referral_rewards (referrer_id, referred_id, order_number, amount)
+					   VALUES ($1, $2, $3, $4)
+					   ON CONFLICT (referred_id) DO NOTHING`
+	tag, err := tx.Exec(ctx, queryInsReward, *referrerID, facts.UserID, facts.OrderNumber, program.Bonus)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	queryUpdBalance := `UPDATE users
+						SET balance = balance + $1
+						WHERE id = $2`
+	_, err = tx.Exec(ctx, queryUpdBalance, program.Bonus, *referrerID)
+	if err != nil {
+		return err
+	}
+	err = addBalanceEvent(ctx, tx, *referrerID)
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Infow("Referral reward credited", "referrerID", *referrerID, "referredID", facts.UserID, "bonus", program.Bonus)
+	return nil
+}
+
+func GetUserReferrals(ctx context.Context, db *pgxpool.Pool, userID int) (*models.ReferralsResponse, error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserReferrals")
+	defer span.End()
+
+	var response models.ReferralsResponse
+	queryCode := `SELECT referral_code FROM users WHERE id = $1`
+	err := db.QueryRow(ctx, queryCode, userID).Scan(&response.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        SELECT u.login, u.created_at, r.amount, r.created_at
+        FROM users u
+        LEFT JOIN referral_rewards r ON r.referred_id = u.id AND r.referrer_id = $1
+        WHERE u.referred_by = $1
+        ORDER BY u.created_at DESC
+    `
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response.Invited = []models.Referral{}
+	for rows.Next() {
+		var referral models.Referral
+		err := rows.Scan(&referral.Login, &referral.RegisteredAt, &referral.Reward, &referral.RewardedAt)
+		if err != nil {
+			return nil, err
+		}
+		if referral.Reward != nil {
+			response.TotalEarned += *referral.Reward
+		}
+		response.Invited = append(response.Invited, referral)
+	}
+	return &response, rows.Err()
+}
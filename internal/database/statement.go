@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ledgerQuery lists every balance movement of the user $1 as (at, kind, reference, amount).
+// Holds are debited when placed and credited back as a release when they end
+// without being spent, so that rows never change once in the past.
+const ledgerQuery = `
+	SELECT COALESCE(processed_at, uploaded_at) AS at, 'ACCRUAL' AS kind, order_number AS reference, accrual AS amount
+	FROM orders WHERE user_id = $1 AND status = 'PROCESSED' AND accrual > 0
+	UNION ALL
+	SELECT created_at, 'BONUS', order_number, amount
+	FROM campaign_bonuses WHERE user_id = $1
+	UNION ALL
+	SELECT created_at, 'REFERRAL', order_number, amount
+	FROM referral_rewards WHERE referrer_id = $1
+	UNION ALL
+	SELECT t.created_at, 'TRANSFER_IN', u.login, t.amount
+	FROM transfers t JOIN users u ON u.id = t.from_user_id WHERE t.to_user_id = $1
+	UNION ALL
+	SELECT t.created_at, 'TRANSFER_OUT', u.login, -t.amount
+	FROM transfers t JOIN users u ON u.id = t.to_user_id WHERE t.from_user_id = $1
+	UNION ALL
+	SELECT withdrawn_at, 'WITHDRAWAL', order_number, -amount
+	FROM withdrawals WHERE user_id = $1
+	UNION ALL
+	SELECT resolved_at, 'RELEASE', order_number, amount
+	FROM withdrawals WHERE user_id = $1 AND status IN ('CANCELLED', 'EXPIRED') AND resolved_at IS NOT NULL
+	UNION ALL
+	SELECT created_at, 'HOLD', 'quote', -amount
+	FROM quotes WHERE user_id = $1
+	UNION ALL
+	SELECT resolved_at, 'RELEASE', 'quote', amount
+	FROM quotes WHERE user_id = $1 AND status IN ('USED', 'EXPIRED') AND resolved_at IS NOT NULL`
+
+// GetBalanceAt returns the user's balance right before t.
+func GetBalanceAt(ctx context.Context, db *pgxpool.Pool, userID int, t time.Time) (float32, error) {
+	ctx, span := tracing.Start(ctx, "database.GetBalanceAt")
+	defer span.End()
+
+	query := `SELECT COALESCE(SUM(amount), 0) FROM (` + ledgerQuery + `) ledger WHERE at < $2`
+
+	var balance float32
+	err := db.QueryRow(ctx, query, userID, t.UTC()).Scan(&balance)
+	return balance, err
+}
+
+// StreamStatement calls fn for every balance movement in [from, to) in chronological order
+// without loading the whole history into memory. Entries carry the running balance starting at opening.
+func StreamStatement(ctx context.Context, db *pgxpool.Pool, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error) {
+	ctx, span := tracing.Start(ctx, "database.StreamStatement")
+	defer span.End()
+
+	query := `SELECT at, kind, reference, amount FROM (` + ledgerQuery + `) ledger
+			  WHERE at >= $2 AND at < $3
+			  ORDER BY at, kind`
+
+	rows, err := db.Query(ctx, query, userID, from.UTC(), to.UTC())
+	if err != nil {
+		return opening, err
+	}
+	defer rows.Close()
+
+	balance := opening
+	for rows.Next() {
+		var entry models.StatementEntry
+		err := rows.Scan(&entry.Date, &entry.Type, &entry.Reference, &entry.Amount)
+		if err != nil {
+			return balance, err
+		}
+		balance += entry.Amount
+		entry.Balance = balance
+		if err := fn(entry); err != nil {
+			return balance, err
+		}
+	}
+	return balance, rows.Err()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// statement returns the entries of the user's statement in [from, to) and the closing balance.
+func statement(t *testing.T, db *pgxpool.Pool, userID int, from, to time.Time) ([]models.StatementEntry, float32) {
+	t.Helper()
+	ctx := context.Background()
+	opening, err := GetBalanceAt(ctx, db, userID, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []models.StatementEntry
+	closing, err := StreamStatement(ctx, db, userID, from, to, opening, func(e models.StatementEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries, closing
+}
+
+func TestStatementHolds(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+	from := time.Now().Add(-time.Minute)
+
+	cancelled, err := ReserveWithdrawal(ctx, db, userID, def, 10, "12345678903", nil, false, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := ReserveWithdrawal(ctx, db, userID, def, 20, "2377225624", nil, false, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := CreateQuote(ctx, db, userID, def, 100, 30, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var held time.Time
+	if err := db.QueryRow(ctx, `SELECT CURRENT_TIMESTAMP`).Scan(&held); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := statement(t, db, userID, from, held)
+	if len(before) != 3 {
+		t.Fatalf("statement while held has %d entries, want 3: %v", len(before), before)
+	}
+
+	if _, err := CancelWithdrawal(ctx, db, userID, cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(t, db, "withdrawals", "id", expired.ID)
+	checkExpireHolds(t, db, 1, 0)
+	if err := WithdrawBalance(ctx, db, userID, def, 25, "9278923470", nil, false, quote.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries already in the past stay as they were.
+	after, _ := statement(t, db, userID, from, held)
+	if !slices.Equal(after, before) {
+		t.Errorf("statement changed after the holds ended:\n%v\nwant\n%v", after, before)
+	}
+
+	entries, closing := statement(t, db, userID, from, time.Now().Add(time.Hour))
+	var releases int
+	for _, e := range entries {
+		if e.Type == models.EntryRelease {
+			releases++
+		}
+	}
+	// The starting balance of newUser is not in the ledger, so the statement closes at the change.
+	if releases != 3 || closing != -25 {
+		t.Errorf("statement has %d releases and closes at %v, want 3 and -25: %v", releases, closing, entries)
+	}
+	checkBalance(t, db, userID, 75, 25)
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrorUserNotFound = errors.New("user not found")
+var ErrorSelfTransfer = errors.New("cannot transfer points to yourself")
+var ErrorTransferLimit = errors.New("daily transfer limit exceeded")
+
+func CreateTransfersTable(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "database.CreateTransfersTable")
+	defer span.End()
+
+	query := `
+    CREATE TABLE IF NOT EXISTS transfers (
+		id SERIAL PRIMARY KEY,
+		from_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		to_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		amount NUMERIC(10, 2) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_transfers_from ON transfers (from_user_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_transfers_to ON transfers (to_user_id, created_at);`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to create table: %w", err)
+	}
+	return nil
+}
+
+// TransferBalance moves amount points from the user to the recipient identified by login.
+// Both balances are locked in id order so concurrent transfers cannot deadlock or overdraw.
+// dailyLimit caps the sum transferred by the sender since the start of the current day, 0 disables it.
+func TransferBalance(ctx context.Context, db *pgxpool.Pool, fromUserID int, toLogin string, amount, dailyLimit float32) error {
+	ctx, span := tracing.Start(ctx, "database.TransferBalance")
+	defer span.End()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var toUserID int
+	queryRecipient := `SELECT id FROM users WHERE login = $1`
+	err = tx.QueryRow(ctx, queryRecipient, toLogin).Scan(&toUserID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrorUserNotFound
+		}
+		return err
+	}
+
+	if toUserID == fromUserID {
+		return ErrorSelfTransfer
+	}
+
+	queryLock := `SELECT id, balance FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`
+	rows, err := tx.Query(ctx, queryLock, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+	var currentBalance float32
+	for rows.Next() {
+		var id int
+		var balance float32
+		if err := rows.Scan(&id, &balance); err != nil {
+			rows.Close()
+			return err
+		}
+		if id == fromUserID {
+			currentBalance = balance
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if dailyLimit > 0 {
+		var transferredToday float32
+		queryToday := `SELECT COALESCE(SUM(amount), 0) FROM transfers
+					   WHERE from_user_id = $1 AND created_at >= date_trunc('day', CURRENT_TIMESTAMP)`
+		err = tx.QueryRow(ctx, queryToday, fromUserID).Scan(&transferredToday)
+		if err != nil {
+			return err
+		}
+		if transferredToday+amount > dailyLimit {
+			logging.FromContext(ctx).Infow("Transfer rejected: daily limit exceeded", "transferredToday", transferredToday, "amount", amount)
+			return ErrorTransferLimit
+		}
+	}
+
+	if currentBalance < amount {
+		return ErrorInsufficientFunds
+	}
+
+	queryDebit := `UPDATE users SET balance = balance - $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryDebit, amount, fromUserID)
+	if err != nil {
+		return err
+	}
+
+	queryCredit := `UPDATE users SET balance = balance + $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryCredit, amount, toUserID)
+	if err != nil {
+		return err
+	}
+
+	queryInsTransfer := `INSERT INTO transfers (from_user_id, to_user_id, amount)
+						 VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, queryInsTransfer, fromUserID, toUserID, amount)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range []int{fromUserID, toUserID} {
+		if err := addBalanceEvent(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	logging.FromContext(ctx).Debugw("Points transferred", "toUserID", toUserID, "amount", amount)
+	return tx.Commit(ctx)
+}
+
+func GetUserTransfers(ctx context.Context, db *pgxpool.Pool, userID int) ([]models.Transfer, error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserTransfers")
+	defer span.End()
+
+	query := `
+        SELECT CASE WHEN t.from_user_id = $1 THEN 'OUT' ELSE 'IN' END,
+			   u.login, t.amount, t.created_at
+        FROM transfers t
+        JOIN users u ON u.id = CASE WHEN t.from_user_id = $1 THEN t.to_user_id ELSE t.from_user_id END
+        WHERE t.from_user_id = $1 OR t.to_user_id = $1
+        ORDER BY t.created_at DESC
+    `
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []models.Transfer
+	for rows.Next() {
+		var transfer models.Transfer
+		err := rows.Scan(&transfer.Direction, &transfer.Counterparty, &transfer.Sum, &transfer.ProcessedAt)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrorOrderRedeemed = errors.New("order already paid with points")
+var ErrorWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrorWithdrawalNotPending = errors.New("withdrawal is not pending")
+var ErrorWithdrawalExpired = errors.New("withdrawal reservation expired")
+
+const withdrawalColumns = `id, order_number, amount, order_value, status, expires_at, withdrawn_at`
+
+// ReserveWithdrawal takes amount points off the user's balance and records a
+// pending withdrawal for the merchant's order, which must be confirmed within hold.
+func ReserveWithdrawal(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error) {
+	ctx, span := tracing.Start(ctx, "database.ReserveWithdrawal")
+	defer span.End()
+
+	return withdraw(ctx, db, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, hold, quote)
+}
+
+// withdraw debits the balance and records the withdrawal, pending for hold if
+// it is positive and confirmed right away otherwise. With a quote token the
+// points held by the quote are spent and the rest is returned to the balance.
+func withdraw(ctx context.Context, db *pgxpool.Pool, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if oncePerOrder {
+		// Older rows may repeat an order number, so uniqueness is enforced with a lock instead of an index.
+		// Order numbers are only unique per merchant.
+		queryLock := `SELECT pg_advisory_xact_lock(hashtext('withdrawal:' || $1::int || ':' || $2::text))`
+		_, err = tx.Exec(ctx, queryLock, merchantID, orderNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		var redeemed bool
+		queryRedeemed := `SELECT EXISTS (SELECT 1 FROM withdrawals
+						  WHERE merchant_id = $1 AND order_number = $2 AND status IN ('PENDING', 'CONFIRMED'))`
+		err = tx.QueryRow(ctx, queryRedeemed, merchantID, orderNumber).Scan(&redeemed)
+		if err != nil {
+			return nil, err
+		}
+		if redeemed {
+			logging.FromContext(ctx).Infow("Withdrawal rejected: order already redeemed", "orderNumber", orderNumber)
+			return nil, ErrorOrderRedeemed
+		}
+	}
+
+	// The balance changes by delta, a quote already took its points off the balance.
+	delta := -amount
+	if quote != "" {
+		held, quotedValue, err := claimQuote(ctx, tx, userID, merchantID, quote, amount)
+		if err != nil {
+			return nil, err
+		}
+		delta = held - amount
+		orderValue = &quotedValue
+	} else {
+		var currentBalance float32
+		queryBalance := `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
+		err = tx.QueryRow(ctx, queryBalance, userID).Scan(&currentBalance)
+		if err != nil {
+			return nil, err
+		}
+
+		if currentBalance < amount {
+			logging.FromContext(ctx).Infow("Withdrawal rejected: insufficient funds", "balance", currentBalance, "amount", amount)
+			return nil, ErrorInsufficientFunds
+		}
+	}
+
+	status := models.WithdrawalStatusConfirmed
+	var withdrawn float32
+	if hold > 0 {
+		status = models.WithdrawalStatusPending
+	} else {
+		withdrawn = amount
+	}
+
+	queryUpdBalance := `UPDATE users
+						SET balance = balance + $1, withdrawn = withdrawn + $2
+						WHERE id = $3`
+	_, err = tx.Exec(ctx, queryUpdBalance, delta, withdrawn, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	queryInsWithdraw := `INSERT INTO withdrawals (user_id, merchant_id, order_number, amount, order_value, status, expires_at, resolved_at)
+						 VALUES ($1, $2, $3, $4, $5, $6,
+							CASE WHEN $6 = 'PENDING' THEN CURRENT_TIMESTAMP + make_interval(secs => $7) END,
+							CASE WHEN $6 = 'CONFIRMED' THEN CURRENT_TIMESTAMP END)
+						 RETURNING ` + withdrawalColumns
+	withdrawal, err := scanWithdrawal(tx.QueryRow(ctx, queryInsWithdraw, userID, merchantID, orderNumber, amount, orderValue, status, hold.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	if quote != "" {
+		queryLinkQuote := `UPDATE quotes SET withdrawal_id = $1 WHERE token = $2`
+		_, err = tx.Exec(ctx, queryLinkQuote, withdrawal.ID, quote)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = addBalanceEvent(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debugw("Balance withdrawn", "orderNumber", orderNumber, "amount", amount, "status", status)
+	return withdrawal, tx.Commit(ctx)
+}
+
+// ConfirmWithdrawal makes the user's pending withdrawal final.
+func ConfirmWithdrawal(ctx context.Context, db *pgxpool.Pool, userID, id int) (*models.Withdrawal, error) {
+	ctx, span := tracing.Start(ctx, "database.ConfirmWithdrawal")
+	defer span.End()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE withdrawals SET status = 'CONFIRMED', resolved_at = CURRENT_TIMESTAMP
+			  WHERE id = $1 AND user_id = $2 AND status = 'PENDING' AND expires_at > CURRENT_TIMESTAMP
+			  RETURNING ` + withdrawalColumns
+	withdrawal, err := scanWithdrawal(tx.QueryRow(ctx, query, id, userID))
+	if err == pgx.ErrNoRows {
+		return nil, unresolvable(ctx, tx, userID, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	queryUpdUser := `UPDATE users SET withdrawn = withdrawn + $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryUpdUser, withdrawal.Sum, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addBalanceEvent(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debugw("Withdrawal confirmed", "withdrawalID", id, "amount", withdrawal.Sum)
+	return withdrawal, tx.Commit(ctx)
+}
+
+// CancelWithdrawal releases the user's pending withdrawal and returns the points to the balance.
+func CancelWithdrawal(ctx context.Context, db *pgxpool.Pool, userID, id int) (*models.Withdrawal, error) {
+	ctx, span := tracing.Start(ctx, "database.CancelWithdrawal")
+	defer span.End()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE withdrawals SET status = 'CANCELLED', resolved_at = CURRENT_TIMESTAMP
+			  WHERE id = $1 AND user_id = $2 AND status = 'PENDING'
+			  RETURNING ` + withdrawalColumns
+	withdrawal, err := scanWithdrawal(tx.QueryRow(ctx, query, id, userID))
+	if err == pgx.ErrNoRows {
+		return nil, unresolvable(ctx, tx, userID, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	queryUpdBalance := `UPDATE users SET balance = balance + $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryUpdBalance, withdrawal.Sum, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addBalanceEvent(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debugw("Withdrawal cancelled", "withdrawalID", id, "amount", withdrawal.Sum)
+	return withdrawal, tx.Commit(ctx)
+}
+
+// unresolvable explains why the withdrawal could not be confirmed or cancelled.
+func unresolvable(ctx context.Context, tx pgx.Tx, userID, id int) error {
+	var status string
+	var expired bool
+	query := `SELECT status, COALESCE(expires_at <= CURRENT_TIMESTAMP, false) FROM withdrawals WHERE id = $1 AND user_id = $2`
+	err := tx.QueryRow(ctx, query, id, userID).Scan(&status, &expired)
+	switch {
+	case err == pgx.ErrNoRows:
+		return ErrorWithdrawalNotFound
+	case err != nil:
+		return err
+	case status == models.WithdrawalStatusExpired || (status == models.WithdrawalStatusPending && expired):
+		return ErrorWithdrawalExpired
+	}
+	return ErrorWithdrawalNotPending
+}
+
+func scanWithdrawal(row pgx.Row) (*models.Withdrawal, error) {
+	var w models.Withdrawal
+	err := row.Scan(&w.ID, &w.OrderNumber, &w.Sum, &w.OrderValue, &w.Status, &w.ExpiresAt, &w.ProcessedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
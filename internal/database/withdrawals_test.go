@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+)
+
+func TestWithdrawOncePerOrder(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+	other := merchantID(t, db, "other")
+
+	if err := WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	err := WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, true, "")
+	if !errors.Is(err, ErrorOrderRedeemed) {
+		t.Fatalf("second withdrawal for the order = %v, want %v", err, ErrorOrderRedeemed)
+	}
+
+	// Order numbers are only unique per merchant.
+	if err := WithdrawBalance(ctx, db, userID, other, 10, "12345678903", nil, true, ""); err != nil {
+		t.Fatalf("withdrawal for another merchant's order: %v", err)
+	}
+	// Without the rule an order can be paid in several withdrawals.
+	if err := WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, false, ""); err != nil {
+		t.Fatalf("withdrawal without once per order: %v", err)
+	}
+
+	// A pending reservation redeems the order until it is cancelled.
+	w, err := ReserveWithdrawal(ctx, db, userID, def, 10, "2377225624", nil, true, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WithdrawBalance(ctx, db, userID, def, 10, "2377225624", nil, true, "")
+	if !errors.Is(err, ErrorOrderRedeemed) {
+		t.Fatalf("withdrawal for a reserved order = %v, want %v", err, ErrorOrderRedeemed)
+	}
+	if _, err := CancelWithdrawal(ctx, db, userID, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := WithdrawBalance(ctx, db, userID, def, 10, "2377225624", nil, true, ""); err != nil {
+		t.Fatalf("withdrawal after the reservation was cancelled: %v", err)
+	}
+
+	checkBalance(t, db, userID, 60, 40)
+}
+
+func TestConfirmWithdrawal(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	otherUser := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+
+	w, err := ReserveWithdrawal(ctx, db, userID, def, 30, "12345678903", nil, true, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != models.WithdrawalStatusPending || w.ExpiresAt == nil {
+		t.Fatalf("reserved withdrawal = %+v, want PENDING with an expiry", w)
+	}
+	// Reserved points leave the balance but are not withdrawn yet.
+	checkBalance(t, db, userID, 70, 0)
+
+	if _, err := ConfirmWithdrawal(ctx, db, otherUser, w.ID); !errors.Is(err, ErrorWithdrawalNotFound) {
+		t.Fatalf("confirming another user's withdrawal = %v, want %v", err, ErrorWithdrawalNotFound)
+	}
+
+	confirmed, err := ConfirmWithdrawal(ctx, db, userID, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.Status != models.WithdrawalStatusConfirmed {
+		t.Fatalf("confirmed withdrawal status = %s, want %s", confirmed.Status, models.WithdrawalStatusConfirmed)
+	}
+	checkBalance(t, db, userID, 70, 30)
+
+	if _, err := ConfirmWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalNotPending) {
+		t.Fatalf("second confirmation = %v, want %v", err, ErrorWithdrawalNotPending)
+	}
+	if _, err := CancelWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalNotPending) {
+		t.Fatalf("cancelling a confirmed withdrawal = %v, want %v", err, ErrorWithdrawalNotPending)
+	}
+	// Confirmed withdrawals never expire.
+	expireNow(t, db, "withdrawals", "id", w.ID)
+	checkExpireHolds(t, db, 0, 0)
+	checkBalance(t, db, userID, 70, 30)
+}
+
+func TestCancelWithdrawal(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+
+	w, err := ReserveWithdrawal(ctx, db, userID, def, 30, "12345678903", nil, true, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := CancelWithdrawal(ctx, db, userID, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != models.WithdrawalStatusCancelled {
+		t.Fatalf("cancelled withdrawal status = %s, want %s", cancelled.Status, models.WithdrawalStatusCancelled)
+	}
+	checkBalance(t, db, userID, 100, 0)
+
+	if _, err := CancelWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalNotPending) {
+		t.Fatalf("second cancellation = %v, want %v", err, ErrorWithdrawalNotPending)
+	}
+	if _, err := ConfirmWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalNotPending) {
+		t.Fatalf("confirming a cancelled withdrawal = %v, want %v", err, ErrorWithdrawalNotPending)
+	}
+	checkBalance(t, db, userID, 100, 0)
+}
+
+func TestExpireWithdrawal(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	userID := newUser(t, db, 100)
+	def := merchantID(t, db, DefaultMerchant)
+
+	w, err := ReserveWithdrawal(ctx, db, userID, def, 30, "12345678903", nil, true, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpireHolds(t, db, 0, 0)
+	expireNow(t, db, "withdrawals", "id", w.ID)
+
+	// Past its expiry the reservation cannot be confirmed, even before it is released.
+	if _, err := ConfirmWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalExpired) {
+		t.Fatalf("confirming an expired withdrawal = %v, want %v", err, ErrorWithdrawalExpired)
+	}
+	checkBalance(t, db, userID, 70, 0)
+
+	checkExpireHolds(t, db, 1, 0)
+	checkBalance(t, db, userID, 100, 0)
+	checkExpireHolds(t, db, 0, 0)
+
+	if _, err := ConfirmWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalExpired) {
+		t.Fatalf("confirming a released withdrawal = %v, want %v", err, ErrorWithdrawalExpired)
+	}
+	if _, err := CancelWithdrawal(ctx, db, userID, w.ID); !errors.Is(err, ErrorWithdrawalExpired) {
+		t.Fatalf("cancelling a released withdrawal = %v, want %v", err, ErrorWithdrawalExpired)
+	}
+	// An expired reservation no longer redeems the order.
+	if err := WithdrawBalance(ctx, db, userID, def, 10, "12345678903", nil, true, ""); err != nil {
+		t.Fatalf("withdrawal after the reservation expired: %v", err)
+	}
+	checkBalance(t, db, userID, 90, 10)
+}
@@ -0,0 +1,179 @@
+// Package events delivers stored user events to subscribers of this instance.
+// Events are announced with pg_notify, so subscribers see events committed by any instance.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// subscriptionBuffer is the number of events a subscriber may fall behind
+// before its subscription is closed.
+const subscriptionBuffer = 64
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+type Hub struct {
+	db *pgxpool.Pool
+
+	mu   sync.Mutex
+	subs map[int]map[*Subscription]struct{}
+}
+
+// Subscription receives the events of a single user. C is closed when the
+// subscriber falls behind or the hub loses its database connection, in which
+// case events may have been missed and should be reloaded from the database.
+type Subscription struct {
+	C <-chan models.Event
+
+	c      chan models.Event
+	hub    *Hub
+	userID int
+}
+
+func NewHub(db *pgxpool.Pool) *Hub {
+	return &Hub{db: db, subs: make(map[int]map[*Subscription]struct{})}
+}
+
+func (h *Hub) Subscribe(userID int) *Subscription {
+	c := make(chan models.Event, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, hub: h, userID: userID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Close unsubscribes s. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(s *Subscription) {
+	subs := h.subs[s.userID]
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subs, s.userID)
+	}
+	close(s.c)
+}
+
+func (h *Hub) publish(event models.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[event.UserID] {
+		select {
+		case s.c <- event:
+		default:
+			logging.Sugar.Warnw("Event subscriber is too slow, closing subscription", "user_id", event.UserID)
+			h.remove(s)
+		}
+	}
+}
+
+// closeAll ends every subscription, subscribers have to catch up from the database.
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
+
+// Run listens for events until ctx is done, reconnecting with backoff when the connection fails.
+func (h *Hub) Run(ctx context.Context) {
+	delay := minRetryDelay
+	for {
+		start := time.Now()
+		err := h.listen(ctx)
+		h.closeAll()
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxRetryDelay {
+			delay = minRetryDelay
+		}
+		logging.Sugar.Errorw("Lost event notifications, reconnecting", "error", err, "delay", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) error {
+	conn, err := h.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is closed rather than returned so that it does not stay subscribed.
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+database.EventsChannel); err != nil {
+		return err
+	}
+	logging.Sugar.Debugw("Listening for events", "channel", database.EventsChannel)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event models.Event
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			logging.Sugar.Errorw("Invalid event notification", "error", err)
+			continue
+		}
+		h.publish(event)
+	}
+}
+
+// Prune deletes events older than retention every hour until ctx is done.
+func Prune(ctx context.Context, db *pgxpool.Pool, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := database.DeleteEventsBefore(ctx, db, time.Now().Add(-retention))
+		if err != nil {
+			logging.Sugar.Errorw("Error pruning events", "error", err)
+		} else if deleted > 0 {
+			logging.Sugar.Infow("Pruned events", "deleted", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package grpcapi
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/grpcapi/loyaltyv1"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type userIDKey struct{}
+
+// publicMethods do not require a token.
+var publicMethods = map[string]bool{
+	loyaltyv1.Loyalty_Register_FullMethodName: true,
+	loyaltyv1.Loyalty_Login_FullMethodName:    true,
+}
+
+func userIDFromContext(ctx context.Context) int {
+	id, _ := ctx.Value(userIDKey{}).(int)
+	return id
+}
+
+// prepare sets up the request ID, logger and span for a call and authenticates it.
+func prepare(ctx context.Context, method string) (context.Context, *tracing.Span, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	requestID := first(md, strings.ToLower(logging.RequestIDHeader))
+	if requestID == "" || len(requestID) > 128 {
+		requestID = logging.NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(logging.RequestIDHeader), requestID))
+	ctx = logging.WithRequestID(ctx, requestID)
+	logging.AddFields(ctx, "method", method)
+
+	ctx, span := tracing.Start(ctx, "grpc "+method)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	span.SetAttribute("request.id", requestID)
+
+	if publicMethods[method] {
+		return ctx, span, nil
+	}
+	token, ok := strings.CutPrefix(first(md, "authorization"), "Bearer ")
+	if !ok {
+		return ctx, span, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	userID, err := auth.GetUserID(token)
+	if err != nil || userID == 0 {
+		return ctx, span, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	logging.WithUserID(ctx, userID)
+	return context.WithValue(ctx, userIDKey{}, userID), span, nil
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// finish logs the call, records its outcome on the span and converts panics into Internal errors.
+func finish(ctx context.Context, span *tracing.Span, start time.Time, err *error) {
+	if rec := recover(); rec != nil {
+		logging.FromContext(ctx).Errorw("Panic while handling call", "panic", rec, "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "internal error")
+	}
+	code := status.Code(*err)
+	if code != codes.OK {
+		span.RecordError(*err)
+	}
+	span.SetAttribute("rpc.grpc.status_code", code.String())
+	span.End()
+
+	logging.FromContext(ctx).Infow(
+		"Call handled",
+		"code", code.String(),
+		"duration", time.Since(start),
+	)
+}
+
+func unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	ctx, span, err := prepare(ctx, info.FullMethod)
+	defer finish(ctx, span, start, &err)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	ctx, span, err := prepare(ss.Context(), info.FullMethod)
+	defer finish(ctx, span, start, &err)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
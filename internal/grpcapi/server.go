@@ -0,0 +1,306 @@
+// Package grpcapi serves the loyalty program over gRPC, see proto/loyalty/v1/loyalty.proto.
+// The generated code in loyaltyv1 is produced with protoc-gen-go and protoc-gen-go-grpc:
+//
+//	protoc -I proto --go_out=internal/grpcapi --go_opt=paths=source_relative \
+//	    --go-grpc_out=internal/grpcapi --go-grpc_opt=paths=source_relative loyalty/v1/loyalty.proto
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/grpcapi/loyaltyv1"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type server struct {
+	loyaltyv1.UnimplementedLoyaltyServer
+
+	svc           *service.LoyaltyService
+	watchInterval time.Duration
+}
+
+// New returns a gRPC server with the Loyalty service registered. If tlsConfig
+// is not nil the server only accepts TLS connections.
+func New(svc *service.LoyaltyService, watchInterval time.Duration, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(unaryInterceptor),
+		grpc.StreamInterceptor(streamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s := grpc.NewServer(opts...)
+	loyaltyv1.RegisterLoyaltyServer(s, &server{svc: svc, watchInterval: watchInterval})
+	return s
+}
+
+var errInternal = status.Error(codes.Internal, "internal error")
+
+// serviceError converts an error returned by the loyalty service into a status error.
+// Unexpected errors are logged with msg and reported as internal errors.
+func serviceError(ctx context.Context, err error, msg string) error {
+	var validation *service.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		return status.Error(codes.InvalidArgument, validation.Message)
+	case errors.Is(err, service.ErrorInvalidCredentials):
+		return status.Error(codes.Unauthenticated, "invalid login or password")
+	case errors.Is(err, service.ErrorLoginTaken):
+		return status.Error(codes.AlreadyExists, "user with this login already exists")
+	case errors.Is(err, service.ErrorInvalidReferralCode):
+		return status.Error(codes.InvalidArgument, "invalid referral code")
+	case errors.Is(err, service.ErrorSelfReferral):
+		return status.Error(codes.InvalidArgument, "self-referral is not allowed")
+	case errors.Is(err, service.ErrorInvalidOrderNumber):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrorUnknownMerchant):
+		return status.Error(codes.InvalidArgument, "unknown merchant")
+	case errors.Is(err, service.ErrorOrderConflict):
+		return status.Error(codes.AlreadyExists, "order already submitted by another user")
+	case errors.Is(err, service.ErrorInsufficientFunds):
+		return status.Error(codes.FailedPrecondition, "insufficient funds")
+	case errors.Is(err, service.ErrorQuoteNotFound):
+		return status.Error(codes.NotFound, "quote not found")
+	case errors.Is(err, service.ErrorQuoteExpired), errors.Is(err, service.ErrorQuoteExceeded):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrorWithdrawalNotFound):
+		return status.Error(codes.NotFound, "withdrawal not found")
+	case errors.Is(err, service.ErrorWithdrawalNotPending), errors.Is(err, service.ErrorWithdrawalExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrorOrderRedeemed):
+		return status.Error(codes.AlreadyExists, "order already paid with points")
+	case errors.Is(err, service.ErrorRedemptionBelowMin), errors.Is(err, service.ErrorRedemptionAboveMax),
+		errors.Is(err, service.ErrorRedemptionShareExceeded):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	logging.FromContext(ctx).Errorw(msg, "error", err)
+	return errInternal
+}
+
+func (s *server) Register(ctx context.Context, req *loyaltyv1.RegisterRequest) (*loyaltyv1.AuthResponse, error) {
+	userID, err := s.svc.Register(ctx, req.Login, req.Password, req.ReferralCode, peerIP(ctx))
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error creating user")
+	}
+	return s.token(ctx, userID)
+}
+
+// peerIP returns the address of the connecting client, empty if unknown.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func (s *server) Login(ctx context.Context, req *loyaltyv1.LoginRequest) (*loyaltyv1.AuthResponse, error) {
+	userID, err := s.svc.Login(ctx, req.Login, req.Password)
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error to find user")
+	}
+	return s.token(ctx, userID)
+}
+
+func (s *server) token(ctx context.Context, userID int) (*loyaltyv1.AuthResponse, error) {
+	token, err := auth.GenerateToken(userID)
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Error while generating token", "error", err)
+		return nil, errInternal
+	}
+	return &loyaltyv1.AuthResponse{
+		Token:     token,
+		ExpiresAt: timestamppb.New(time.Now().Add(auth.TokenExp)),
+	}, nil
+}
+
+func (s *server) SubmitOrder(ctx context.Context, req *loyaltyv1.SubmitOrderRequest) (*loyaltyv1.SubmitOrderResponse, error) {
+	created, err := s.svc.SubmitOrder(ctx, userIDFromContext(ctx), req.Merchant, req.Number)
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error adding order")
+	}
+	return &loyaltyv1.SubmitOrderResponse{AlreadySubmitted: !created}, nil
+}
+
+func (s *server) ListOrders(ctx context.Context, req *loyaltyv1.ListOrdersRequest) (*loyaltyv1.ListOrdersResponse, error) {
+	params, err := pageParams(req.Limit, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range req.Statuses {
+		st = strings.ToUpper(strings.TrimSpace(st))
+		if !slices.Contains(orderStatuses, st) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", st)
+		}
+		params.Statuses = append(params.Statuses, st)
+	}
+
+	orders, next, err := s.svc.ListOrders(ctx, userIDFromContext(ctx), params)
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error fetching orders")
+	}
+
+	resp := &loyaltyv1.ListOrdersResponse{NextCursor: encodeCursor(next)}
+	for _, order := range orders {
+		resp.Orders = append(resp.Orders, orderMessage(order))
+	}
+	return resp, nil
+}
+
+func (s *server) GetBalance(ctx context.Context, _ *loyaltyv1.GetBalanceRequest) (*loyaltyv1.Balance, error) {
+	balance, err := s.svc.Balance(ctx, userIDFromContext(ctx))
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error fetching balance")
+	}
+	return &loyaltyv1.Balance{
+		Current:   float64(balance.Current),
+		Withdrawn: float64(balance.Withdrawn),
+	}, nil
+}
+
+func (s *server) Withdraw(ctx context.Context, req *loyaltyv1.WithdrawRequest) (*loyaltyv1.WithdrawResponse, error) {
+	err := s.svc.Withdraw(ctx, userIDFromContext(ctx), models.WithdrawRequest{OrderNumber: req.Order, Sum: float32(req.Sum), Merchant: req.Merchant})
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error to withdraw")
+	}
+	return &loyaltyv1.WithdrawResponse{}, nil
+}
+
+func (s *server) ListWithdrawals(ctx context.Context, req *loyaltyv1.ListWithdrawalsRequest) (*loyaltyv1.ListWithdrawalsResponse, error) {
+	params, err := pageParams(req.Limit, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawals, next, err := s.svc.ListWithdrawals(ctx, userIDFromContext(ctx), params)
+	if err != nil {
+		return nil, serviceError(ctx, err, "Error fetching withdrawals")
+	}
+
+	resp := &loyaltyv1.ListWithdrawalsResponse{NextCursor: encodeCursor(next)}
+	for _, w := range withdrawals {
+		resp.Withdrawals = append(resp.Withdrawals, &loyaltyv1.Withdrawal{
+			Order:       w.OrderNumber,
+			Sum:         float64(w.Sum),
+			ProcessedAt: timestamppb.New(w.ProcessedAt),
+		})
+	}
+	return resp, nil
+}
+
+// WatchOrders polls the user's orders and sends every status change.
+func (s *server) WatchOrders(req *loyaltyv1.WatchOrdersRequest, stream loyaltyv1.Loyalty_WatchOrdersServer) error {
+	ctx := stream.Context()
+	userID := userIDFromContext(ctx)
+
+	watched := make(map[string]bool)
+	for _, number := range req.Numbers {
+		watched[number] = true
+	}
+	// Order numbers are only unique per merchant.
+	sent := make(map[string]string)
+	found := make(map[string]bool)
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		orders, _, err := s.svc.ListOrders(ctx, userID, &pagination.Params{Limit: pagination.MaxLimit})
+		if err != nil {
+			return serviceError(ctx, err, "Error fetching orders")
+		}
+
+		pending := 0
+		for _, order := range orders {
+			if len(watched) > 0 && !watched[order.OrderNumber] {
+				continue
+			}
+			found[order.OrderNumber] = true
+			key := order.Merchant.Code + "/" + order.OrderNumber
+			if sent[key] != order.Status {
+				if err := stream.Send(orderMessage(order)); err != nil {
+					return err
+				}
+				sent[key] = order.Status
+			}
+			if order.Status != models.OrderStatusProcessed && order.Status != models.OrderStatusInvalid {
+				pending++
+			}
+		}
+		if len(watched) > 0 {
+			if len(found) < len(watched) {
+				return status.Error(codes.NotFound, "order not found")
+			}
+			if pending == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+var orderStatuses = []string{models.OrderStatusNew, models.OrderStatusProcessing,
+	models.OrderStatusInvalid, models.OrderStatusProcessed}
+
+func orderMessage(order models.Order) *loyaltyv1.Order {
+	msg := &loyaltyv1.Order{
+		Number:     order.OrderNumber,
+		Status:     order.Status,
+		UploadedAt: timestamppb.New(order.UploadedAt),
+		Merchant:   order.Merchant.Code,
+	}
+	if order.Accrual != nil {
+		msg.Accrual = float64(*order.Accrual)
+	}
+	return msg
+}
+
+func pageParams(limit int32, cursor string) (*pagination.Params, error) {
+	params := &pagination.Params{Limit: pagination.DefaultLimit}
+	if limit != 0 {
+		if limit < 1 || limit > pagination.MaxLimit {
+			return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", pagination.MaxLimit)
+		}
+		params.Limit = int(limit)
+	}
+	if cursor != "" {
+		after, err := pagination.Decode(cursor)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		params.After = after
+	}
+	return params, nil
+}
+
+func encodeCursor(c *pagination.Cursor) string {
+	if c == nil {
+		return ""
+	}
+	return c.Encode()
+}
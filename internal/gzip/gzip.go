@@ -5,11 +5,21 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/server"
 )
 
+// MaxDecompressedSize limits the size of a gzip request body after decompression.
+// A lower limit set for the route with server.MaxBodySize applies instead.
+var MaxDecompressedSize int64 = 1 << 20
+
 type CompressWriter struct {
 	w  http.ResponseWriter
 	zw *gzip.Writer
+
+	wroteHeader bool
+	compress    bool
 }
 
 func NewCompressWriter(w http.ResponseWriter) *CompressWriter {
@@ -24,17 +34,48 @@ func (c *CompressWriter) Header() http.Header {
 }
 
 func (c *CompressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.compress {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
+// WriteHeader enables compression for successful responses with a body only,
+// errors are sent as is.
 func (c *CompressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	if statusCode < 300 && statusCode != http.StatusNoContent {
+		c.compress = true
 		c.w.Header().Set("Content-Encoding", "gzip")
+		c.w.Header().Del("Content-Length")
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush sends the compressed data written so far to the client.
+func (c *CompressWriter) Flush() {
+	if c.compress {
+		c.zw.Flush()
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *CompressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 func (c *CompressWriter) Close() error {
+	if !c.compress {
+		return nil
+	}
 	return c.zw.Close()
 }
 
@@ -83,11 +124,15 @@ func Middleware(h http.HandlerFunc) http.HandlerFunc {
 		if sendsGzip {
 			cr, err := NewCompressReader(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid gzip request body")
 				return
 			}
-			r.Body = cr
 			defer cr.Close()
+			limit := MaxDecompressedSize
+			if n := server.BodyLimit(r.Context()); n > 0 && n < limit {
+				limit = n
+			}
+			r.Body = http.MaxBytesReader(w, cr, limit)
 		}
 
 		h.ServeHTTP(ow, r)
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/go-chi/chi"
+)
+
+func CreateCampaign(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.CampaignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BodyError(w, r, err)
+			return
+		}
+
+		campaign, err := svc.CreateCampaign(r.Context(), &req)
+		if err != nil {
+			serviceError(w, r, err, "Error creating campaign")
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, campaign)
+	}
+}
+
+func PauseCampaign(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid campaign id")
+			return
+		}
+
+		campaign, err := svc.PauseCampaign(r.Context(), id)
+		if err != nil {
+			serviceError(w, r, err, "Error pausing campaign")
+			return
+		}
+
+		response.JSON(w, http.StatusOK, campaign)
+	}
+}
+
+func ResumeCampaign(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid campaign id")
+			return
+		}
+
+		campaign, err := svc.ResumeCampaign(r.Context(), id)
+		if err != nil {
+			serviceError(w, r, err, "Error resuming campaign")
+			return
+		}
+
+		response.JSON(w, http.StatusOK, campaign)
+	}
+}
+
+// PreviewCampaign evaluates the campaign against orders processed in the
+// [from, to) range, defaulting to the campaign's own date range.
+func PreviewCampaign(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid campaign id")
+			return
+		}
+
+		var from, to time.Time
+		if v := r.URL.Query().Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "Invalid from parameter",
+					response.FieldError{Field: "from", Message: "must be an RFC 3339 timestamp"})
+				return
+			}
+		}
+		if v := r.URL.Query().Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "Invalid to parameter",
+					response.FieldError{Field: "to", Message: "must be an RFC 3339 timestamp"})
+				return
+			}
+		}
+
+		preview, err := svc.PreviewCampaign(r.Context(), id, from, to)
+		if err != nil {
+			serviceError(w, r, err, "Error previewing campaign")
+			return
+		}
+
+		response.JSON(w, http.StatusOK, preview)
+	}
+}
+
+// CreateMerchant registers a merchant. Orders are submitted for it with its code,
+// and an empty accrual_address keeps them on the configured accrual system.
+func CreateMerchant(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.MerchantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BodyError(w, r, err)
+			return
+		}
+
+		merchant, err := svc.CreateMerchant(r.Context(), &req)
+		if err != nil {
+			serviceError(w, r, err, "Error creating merchant")
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, merchant)
+	}
+}
+
+func GetMerchants(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		merchants, err := svc.ListMerchants(r.Context())
+		if err != nil {
+			serviceError(w, r, err, "Error fetching merchants")
+			return
+		}
+
+		response.JSON(w, http.StatusOK, merchants)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+)
+
+// serviceError writes the problem for an error returned by the loyalty service.
+// Unexpected errors are logged with msg and reported as internal errors.
+func serviceError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	var validation *service.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		var fields []response.FieldError
+		for _, f := range validation.Fields {
+			fields = append(fields, response.FieldError{Field: f.Field, Message: f.Message})
+		}
+		response.Error(w, r, http.StatusBadRequest, response.CodeValidationFailed, validation.Message, fields...)
+	case errors.Is(err, service.ErrorInvalidCredentials):
+		response.Error(w, r, http.StatusUnauthorized, response.CodeInvalidCredentials, "Invalid login or password")
+	case errors.Is(err, service.ErrorLoginTaken):
+		response.Error(w, r, http.StatusConflict, response.CodeLoginTaken, "User with this login already exists")
+	case errors.Is(err, service.ErrorInvalidReferralCode):
+		response.Error(w, r, http.StatusBadRequest, response.CodeInvalidReferralCode, "Invalid referral code")
+	case errors.Is(err, service.ErrorSelfReferral):
+		response.Error(w, r, http.StatusBadRequest, response.CodeSelfReferral, "Self-referral is not allowed")
+	case errors.Is(err, service.ErrorInvalidOrderNumber):
+		detail := "Invalid order number format"
+		var reason *ordernumber.Error
+		if errors.As(err, &reason) {
+			detail = "Order number " + reason.Reason
+		}
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeInvalidOrderNumber, detail)
+	case errors.Is(err, service.ErrorUnknownMerchant):
+		response.Error(w, r, http.StatusBadRequest, response.CodeUnknownMerchant, "Unknown merchant")
+	case errors.Is(err, service.ErrorOrderConflict):
+		response.Error(w, r, http.StatusConflict, response.CodeOrderConflict, "Order already submitted by another user")
+	case errors.Is(err, service.ErrorInsufficientFunds):
+		response.Error(w, r, http.StatusPaymentRequired, response.CodeInsufficientFunds, "Insufficient funds")
+	case errors.Is(err, service.ErrorOrderRedeemed):
+		response.Error(w, r, http.StatusConflict, response.CodeOrderRedeemed, "Order was already paid with points")
+	case errors.Is(err, service.ErrorRedemptionBelowMin):
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeRedemptionBelowMin, redemptionDetail(err))
+	case errors.Is(err, service.ErrorRedemptionAboveMax):
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeRedemptionAboveMax, redemptionDetail(err))
+	case errors.Is(err, service.ErrorRedemptionShareExceeded):
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeRedemptionShare, redemptionDetail(err))
+	case errors.Is(err, service.ErrorWithdrawalNotFound):
+		response.Error(w, r, http.StatusNotFound, response.CodeWithdrawalNotFound, "Withdrawal not found")
+	case errors.Is(err, service.ErrorWithdrawalNotPending):
+		response.Error(w, r, http.StatusConflict, response.CodeWithdrawalResolved, "Withdrawal was already confirmed or cancelled")
+	case errors.Is(err, service.ErrorWithdrawalExpired):
+		response.Error(w, r, http.StatusConflict, response.CodeWithdrawalExpired, "Withdrawal reservation expired")
+	case errors.Is(err, service.ErrorQuoteNotFound):
+		response.Error(w, r, http.StatusNotFound, response.CodeQuoteNotFound, "Quote not found")
+	case errors.Is(err, service.ErrorQuoteExpired):
+		response.Error(w, r, http.StatusConflict, response.CodeQuoteExpired, "Quote expired or already used")
+	case errors.Is(err, service.ErrorQuoteExceeded):
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeQuoteExceeded, "Withdrawal exceeds the quoted amount")
+	case errors.Is(err, service.ErrorRecipientNotFound):
+		response.Error(w, r, http.StatusNotFound, response.CodeRecipientNotFound, "Recipient not found")
+	case errors.Is(err, service.ErrorSelfTransfer):
+		response.Error(w, r, http.StatusBadRequest, response.CodeSelfTransfer, "Cannot transfer points to yourself")
+	case errors.Is(err, service.ErrorTransferLimitReached):
+		response.Error(w, r, http.StatusUnprocessableEntity, response.CodeTransferLimit, "Daily transfer limit exceeded")
+	case errors.Is(err, service.ErrorCampaignNotFound):
+		response.Error(w, r, http.StatusNotFound, response.CodeCampaignNotFound, "Campaign not found")
+	case errors.Is(err, service.ErrorMerchantExists):
+		response.Error(w, r, http.StatusConflict, response.CodeMerchantExists, "Merchant with this code already exists")
+	default:
+		logging.FromContext(r.Context()).Errorw(msg, "error", err)
+		response.Internal(w, r)
+	}
+}
+
+// redemptionDetail turns a redemption rule error, which names the limit, into a problem detail.
+func redemptionDetail(err error) string {
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
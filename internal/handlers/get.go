@@ -1,31 +1,39 @@
 package handlers
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
-	"sort"
 	"time"
 
 	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
-	"github.com/KirillZiborov/go-loyalty-program/internal/database"
 	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
 	"github.com/KirillZiborov/go-loyalty-program/internal/models"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/KirillZiborov/go-loyalty-program/internal/statement"
 )
 
-func GetOrders(db *pgxpool.Pool) http.HandlerFunc {
+func GetOrders(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := auth.AuthGet(r)
 		if err != nil || userID == 0 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r)
 			return
 		}
 
-		orders, err := database.GetOrdersByUserID(r.Context(), db, userID)
+		params, err := pagination.Parse(r, models.OrderStatusNew, models.OrderStatusProcessing,
+			models.OrderStatusInvalid, models.OrderStatusProcessed)
 		if err != nil {
-			logging.Sugar.Errorw("Error fetching orders:", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, err.Error())
+			return
+		}
+
+		orders, next, err := svc.ListOrders(r.Context(), userID, params)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error fetching orders", "error", err)
+			response.Internal(w, r)
 			return
 		}
 
@@ -34,70 +42,71 @@ func GetOrders(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		sort.Slice(orders, func(i, j int) bool {
-			return orders[i].UploadedAt.After(orders[j].UploadedAt)
-		})
-
-		var response []models.OrderResponse
+		var result []models.OrderResponse
 		for _, order := range orders {
 			resp := models.OrderResponse{
 				OrderNumber: order.OrderNumber,
 				Status:      order.Status,
 				UploadedAt:  order.UploadedAt.Format(time.RFC3339),
+				Merchant:    &order.Merchant,
 			}
 
-			if order.Status == "PROCESSED" && order.Accrual != nil {
+			if order.Accrual != nil {
 				resp.Accrual = *order.Accrual
 			}
-			response = append(response, resp)
+			result = append(result, resp)
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+		pagination.SetNext(w, r, next)
+		response.JSON(w, http.StatusOK, result)
 	}
 }
 
-func GetBalance(db *pgxpool.Pool) http.HandlerFunc {
+func GetBalance(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := auth.AuthGet(r)
 		if err != nil || userID == 0 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r)
 			return
 		}
 
-		balance, err := database.GetUserBalance(r.Context(), db, userID)
+		balance, err := svc.Balance(r.Context(), userID)
 		if err != nil {
-			logging.Sugar.Errorw("Error fetching balance:", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Errorw("Error fetching balance", "error", err)
+			response.Internal(w, r)
 			return
 		}
 
-		response := models.BalanceResponse{
+		result := models.BalanceResponse{
 			Current:   balance.Current,
 			Withdrawn: balance.Withdrawn,
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+		response.JSON(w, http.StatusOK, result)
 	}
 }
 
-func GetWithdrawals(db *pgxpool.Pool) http.HandlerFunc {
+func GetWithdrawals(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := auth.AuthGet(r)
 		if err != nil || userID == 0 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r)
 			return
 		}
 
-		withdrawals, err := database.GetUserWithdrawals(r.Context(), db, userID)
+		params, err := pagination.Parse(r, models.WithdrawalStatusPending, models.WithdrawalStatusConfirmed,
+			models.WithdrawalStatusCancelled, models.WithdrawalStatusExpired)
 		if err != nil {
-			logging.Sugar.Errorw("Error fetching withdrawals:", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, err.Error())
+			return
+		}
+
+		withdrawals, next, err := svc.ListWithdrawals(r.Context(), userID, params)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error fetching withdrawals", "error", err)
+			response.Internal(w, r)
 			return
 		}
 
@@ -106,12 +115,136 @@ func GetWithdrawals(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		sort.Slice(withdrawals, func(i, j int) bool {
-			return withdrawals[i].ProcessedAt.After(withdrawals[j].ProcessedAt)
-		})
+		pagination.SetNext(w, r, next)
+		response.JSON(w, http.StatusOK, withdrawals)
+	}
+}
+
+func GetTransfers(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		transfers, err := svc.ListTransfers(r.Context(), userID)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error fetching transfers", "error", err)
+			response.Internal(w, r)
+			return
+		}
 
-		w.Header().Set("Content-Type", "application/json")
+		if len(transfers) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, transfers)
+	}
+}
+
+func GetReferrals(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		referrals, err := svc.Referrals(r.Context(), userID)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error fetching referrals", "error", err)
+			response.Internal(w, r)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, referrals)
+	}
+}
+
+const statementFlushEvery = 100
+
+// GetStatement streams the user's statement for [from, to) in csv, jsonl or txt format.
+func GetStatement(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		from, to := time.Unix(0, 0).UTC(), time.Now().UTC()
+		if v := q.Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "Invalid from parameter",
+					response.FieldError{Field: "from", Message: "must be an RFC 3339 timestamp"})
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "Invalid to parameter",
+					response.FieldError{Field: "to", Message: "must be an RFC 3339 timestamp"})
+				return
+			}
+		}
+		if !to.After(from) {
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "to must be after from")
+			return
+		}
+
+		format := q.Get("format")
+		if format == "" {
+			format = statement.FormatCSV
+		}
+		writer, err := statement.NewWriter(format, w)
+		if err != nil {
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidQuery, "Unknown format, expected csv, jsonl or txt",
+				response.FieldError{Field: "format", Message: "must be csv, jsonl or txt"})
+			return
+		}
+
+		ctx := r.Context()
+		opening, err := svc.OpeningBalance(ctx, userID, from)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error fetching opening balance", "error", err)
+			response.Internal(w, r)
+			return
+		}
+
+		// Large exports may take longer than the server's write timeout.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", statement.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"statement.%s\"", format))
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(withdrawals)
+
+		flusher, _ := w.(http.Flusher)
+		if err := writer.Opening(from, opening); err != nil {
+			return
+		}
+
+		written := 0
+		closing, err := svc.Statement(ctx, userID, from, to, opening, func(e models.StatementEntry) error {
+			if err := writer.Entry(e); err != nil {
+				return err
+			}
+			written++
+			if flusher != nil && written%statementFlushEvery == 0 {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Errorw("Error streaming statement", "error", err)
+			return
+		}
+
+		writer.Closing(to, closing)
 	}
 }
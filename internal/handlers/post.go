@@ -4,191 +4,288 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
-	"github.com/KirillZiborov/go-loyalty-program/internal/database"
-	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
 	"github.com/KirillZiborov/go-loyalty-program/internal/models"
-	"github.com/KirillZiborov/go-loyalty-program/internal/utils"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+	"github.com/go-chi/chi"
 )
 
-func RegisterUser(db *pgxpool.Pool) http.HandlerFunc {
+// RegisterUser creates a user. The client address is taken from ipHeader when it
+// is set by a trusted proxy, otherwise from the connection.
+func RegisterUser(svc *service.LoyaltyService, ipHeader string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var user models.User
 
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+			response.BodyError(w, r, err)
 			return
 		}
 
-		if user.Login == "" || user.Password == "" {
-			http.Error(w, "Login and password are required", http.StatusBadRequest)
+		userID, err := svc.Register(r.Context(), user.Login, user.Password, user.ReferralCode, clientIP(r, ipHeader))
+		if err != nil {
+			serviceError(w, r, err, "Error creating user")
 			return
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		err = auth.AuthPost(w, r, userID)
 		if err != nil {
-			logging.Sugar.Errorw("Error hashing password", "error", err)
-			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+			response.Internal(w, r)
+			return
+		}
+
+		response.Message(w, http.StatusOK, "User registered successfully")
+	}
+}
+
+// clientIP returns the address of the client, empty if unknown. With header the
+// last address in it is used, as that is the one appended by the trusted proxy.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		values := strings.Split(r.Header.Get(header), ",")
+		ip := net.ParseIP(strings.TrimSpace(values[len(values)-1]))
+		if ip == nil {
+			return ""
+		}
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func LoginUser(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var user models.User
+
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			response.BodyError(w, r, err)
 			return
 		}
-		user.Password = string(hashedPassword)
 
-		userID, err := database.CreateUser(r.Context(), db, &user)
+		userID, err := svc.Login(r.Context(), user.Login, user.Password)
 		if err != nil {
-			if err == database.ErrorDuplicate {
-				http.Error(w, "User with this login already exists", http.StatusConflict)
-			} else {
-				logging.Sugar.Errorw("Error creating user", "error", err)
-				http.Error(w, "Error creating user", http.StatusInternalServerError)
-			}
+			serviceError(w, r, err, "Error to find user")
 			return
 		}
 
 		err = auth.AuthPost(w, r, userID)
 		if err != nil {
-			http.Error(w, "Error setting authentication cookie", http.StatusInternalServerError)
+			response.Internal(w, r)
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+		response.Message(w, http.StatusOK, "Logged in successfully")
 	}
 }
 
-func LoginUser(db *pgxpool.Pool) http.HandlerFunc {
+func SubmitOrder(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var user models.User
-
-		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
 			return
 		}
 
-		if user.Login == "" || user.Password == "" {
-			http.Error(w, "Login and password are required", http.StatusBadRequest)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			response.BodyError(w, r, err)
 			return
 		}
 
-		storedUser, err := database.GetUserByLogin(context.Background(), db, user.Login)
+		created, err := svc.SubmitOrder(r.Context(), userID, r.URL.Query().Get("merchant"), string(body))
 		if err != nil {
-			logging.Sugar.Errorw("Error to find user", "error", err)
-			http.Error(w, "Error to find user", http.StatusInternalServerError)
+			serviceError(w, r, err, "Error adding order")
 			return
 		}
-		if storedUser == nil {
-			http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+
+		if !created {
+			response.Message(w, http.StatusOK, "Order already submitted by this user")
 			return
 		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
 
-		err = bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password))
-		if err != nil {
-			http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+// SubmitOrders accepts a JSON array or newline separated order numbers and reports the result of each.
+func SubmitOrders(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
 			return
 		}
 
-		err = auth.AuthPost(w, r, storedUser.ID)
+		var numbers []string
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == response.ContentTypeJSON {
+			if err := json.NewDecoder(r.Body).Decode(&numbers); err != nil {
+				response.BodyError(w, r, err)
+				return
+			}
+		} else {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				response.BodyError(w, r, err)
+				return
+			}
+			for _, line := range strings.Split(string(body), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					numbers = append(numbers, line)
+				}
+			}
+		}
+
+		results, err := svc.SubmitOrders(r.Context(), userID, r.URL.Query().Get("merchant"), numbers)
 		if err != nil {
-			http.Error(w, "Error getting token", http.StatusInternalServerError)
+			serviceError(w, r, err, "Error adding orders")
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
+		response.JSON(w, http.StatusOK, results)
 	}
 }
 
-func SubmitOrder(db *pgxpool.Pool) http.HandlerFunc {
+func Withdraw(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := auth.AuthGet(r)
 		if err != nil || userID == 0 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r)
 			return
 		}
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Invalid request format", http.StatusBadRequest)
+		var req models.WithdrawRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BodyError(w, r, err)
 			return
 		}
 
-		orderNumber := strings.TrimSpace(string(body))
-		if !utils.CheckLuhn(orderNumber) {
-			http.Error(w, "Invalid order number format", http.StatusUnprocessableEntity)
+		err = svc.Withdraw(r.Context(), userID, req)
+		if err != nil {
+			serviceError(w, r, err, "Error to withdraw")
 			return
 		}
 
-		ctx := r.Context()
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		exists, ownerID, err := database.OrderExists(ctx, db, orderNumber)
-		if err != nil {
-			logging.Sugar.Errorw("Error to find order", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+// Quote reports how many points can be redeemed on an order and holds them for a subsequent withdrawal.
+func Quote(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
 			return
 		}
 
-		if exists {
-			if ownerID == userID {
-				http.Error(w, "Order already submitted by this user", http.StatusOK)
-			} else {
-				http.Error(w, "Order already submitted by another user", http.StatusConflict)
-			}
+		var req models.QuoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BodyError(w, r, err)
 			return
 		}
 
-		err = database.AddOrder(ctx, db, userID, orderNumber)
+		quote, err := svc.Quote(r.Context(), userID, req.Merchant, req.OrderValue)
 		if err != nil {
-			logging.Sugar.Errorw("Error adding user", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			serviceError(w, r, err, "Error to quote redemption")
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		response.JSON(w, http.StatusOK, quote)
 	}
 }
 
-func Withdraw(db *pgxpool.Pool) http.HandlerFunc {
+// ReserveWithdrawal holds points for an order until the checkout confirms or cancels the withdrawal.
+func ReserveWithdrawal(svc *service.LoyaltyService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := auth.AuthGet(r)
 		if err != nil || userID == 0 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r)
 			return
 		}
 
 		var req models.WithdrawRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+			response.BodyError(w, r, err)
 			return
 		}
 
-		if !utils.CheckLuhn(req.OrderNumber) {
-			http.Error(w, "Invalid order number format", http.StatusUnprocessableEntity)
+		withdrawal, err := svc.ReserveWithdrawal(r.Context(), userID, req)
+		if err != nil {
+			serviceError(w, r, err, "Error to reserve withdrawal")
 			return
 		}
 
-		if req.Sum <= 0 {
-			http.Error(w, "Invalid amount", http.StatusBadRequest)
+		response.JSON(w, http.StatusCreated, withdrawal)
+	}
+}
+
+func ConfirmWithdrawal(svc *service.LoyaltyService) http.HandlerFunc {
+	return resolveWithdrawal(svc.ConfirmWithdrawal, "Error to confirm withdrawal")
+}
+
+func CancelWithdrawal(svc *service.LoyaltyService) http.HandlerFunc {
+	return resolveWithdrawal(svc.CancelWithdrawal, "Error to cancel withdrawal")
+}
+
+func resolveWithdrawal(resolve func(ctx context.Context, userID, id int) (*models.Withdrawal, error), msg string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
 			return
 		}
 
-		err = database.WithdrawBalance(r.Context(), db, userID, req.Sum, req.OrderNumber)
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
 		if err != nil {
-			if err == database.ErrorInsufficientFunds {
-				http.Error(w, "Insufficient funds", http.StatusPaymentRequired)
-				return
-			}
+			response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid withdrawal id")
+			return
+		}
+
+		withdrawal, err := resolve(r.Context(), userID, id)
+		if err != nil {
+			serviceError(w, r, err, msg)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, withdrawal)
+	}
+}
 
-			logging.Sugar.Errorw("Error to withdraw", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+func Transfer(svc *service.LoyaltyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		var req models.TransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.BodyError(w, r, err)
+			return
+		}
+
+		err = svc.Transfer(r.Context(), userID, req.To, req.Sum)
+		if err != nil {
+			serviceError(w, r, err, "Error to transfer")
 			return
 		}
 
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/events"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service"
+)
+
+// streamRetry is the reconnection delay suggested to clients, in milliseconds.
+const streamRetry = 3000
+
+// StreamEvents pushes the user's order and balance events as Server-Sent Events.
+// Clients resuming with Last-Event-ID first receive the stored events they missed.
+func StreamEvents(svc *service.LoyaltyService, hub *events.Hub, heartbeat time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		var lastID int64
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			lastID, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || lastID < 0 {
+				response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Invalid Last-Event-ID header")
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		// The stream outlives the server's read and write timeouts.
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+
+		// Subscribe before replaying so that nothing committed in between is lost.
+		sub := hub.Subscribe(userID)
+		defer sub.Close()
+
+		metrics.EventStreams.Add(1, "sse")
+		defer metrics.EventStreams.Add(-1, "sse")
+
+		// The stream starts with the first replayed event, so that a replay
+		// failing before anything was sent is still reported as an error.
+		started := false
+		start := func() error {
+			if started {
+				return nil
+			}
+			started = true
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			_, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+			return err
+		}
+
+		send := func(e models.Event) error {
+			if e.ID <= lastID {
+				return nil
+			}
+			if err := start(); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data); err != nil {
+				return err
+			}
+			lastID = e.ID
+			return nil
+		}
+
+		ctx := r.Context()
+		if lastID > 0 {
+			if err := svc.EventsAfter(ctx, userID, lastID, send); err != nil {
+				logging.FromContext(ctx).Errorw("Error replaying events", "error", err)
+				if !started {
+					response.Internal(w, r)
+				}
+				return
+			}
+		}
+		if err := start(); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			logging.FromContext(ctx).Errorw("Streaming is not supported", "error", err)
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					// Events may have been missed, the client resumes from lastID.
+					return
+				}
+				if err := send(e); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
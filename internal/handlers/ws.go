@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/auth"
+	"github.com/KirillZiborov/go-loyalty-program/internal/events"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsMaxMessageSize = 4 << 10
+	wsWriteWait      = 10 * time.Second
+	// wsPendingRequests is the number of client messages that may wait for a reply.
+	wsPendingRequests = 16
+)
+
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsSubscribed  = "subscribed"
+	wsEvent       = "event"
+	wsError       = "error"
+)
+
+var wsEventTypes = []string{models.EventOrder, models.EventBalance}
+
+// wsMessage is the JSON message exchanged in both directions. Clients send
+// subscribe and unsubscribe with the event types, the server answers with
+// subscribed listing the current subscriptions, and sends event and error messages.
+type wsMessage struct {
+	Type   string          `json:"type"`
+	Events []string        `json:"events,omitempty"`
+	ID     int64           `json:"id,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Websocket delivers the user's order and balance events over a WebSocket.
+// Connections start without subscriptions. A connection that cannot keep up
+// is closed with 1013 Try Again Later.
+// allowedOrigins lists the browser origins allowed to connect besides the API's own.
+func Websocket(hub *events.Hub, heartbeat time.Duration, allowedOrigins []string) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			code := response.CodeInvalidInput
+			if status == http.StatusForbidden {
+				code = response.CodeForbidden
+			}
+			response.Error(w, r, status, code, reason.Error())
+		},
+	}
+	if len(allowedOrigins) > 0 {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || origin == "http://"+r.Host || origin == "https://"+r.Host ||
+				slices.Contains(allowedOrigins, origin)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := auth.AuthGet(r)
+		if err != nil || userID == 0 {
+			response.Unauthorized(w, r)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sub := hub.Subscribe(userID)
+		defer sub.Close()
+
+		metrics.EventStreams.Add(1, "websocket")
+		defer metrics.EventStreams.Add(-1, "websocket")
+
+		logger := logging.FromContext(r.Context())
+		requests := make(chan wsMessage, wsPendingRequests)
+		go wsRead(conn, heartbeat, requests)
+
+		write := func(msg wsMessage) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			return conn.WriteJSON(msg)
+		}
+
+		subscribed := make(map[string]bool)
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					return
+				}
+				if err := write(wsReply(req, subscribed)); err != nil {
+					return
+				}
+			case e, ok := <-sub.C:
+				if !ok {
+					logger.Infow("Closing slow WebSocket client")
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "events were dropped"),
+						time.Now().Add(wsWriteWait))
+					return
+				}
+				if !subscribed[e.Type] {
+					continue
+				}
+				if err := write(wsMessage{Type: wsEvent, ID: e.ID, Event: e.Type, Data: e.Data}); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// wsRead passes client messages to requests until the connection fails or
+// the client stops answering pings, then closes requests.
+func wsRead(conn *websocket.Conn, heartbeat time.Duration, requests chan<- wsMessage) {
+	defer close(requests)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg wsMessage
+		if kind != websocket.TextMessage || json.Unmarshal(data, &msg) != nil {
+			msg = wsMessage{Type: wsError, Error: "messages must be JSON text"}
+		}
+		select {
+		case requests <- msg:
+		default:
+			// The client sends faster than it reads the replies.
+			return
+		}
+	}
+}
+
+// wsReply applies a client message to subscribed and returns the answer.
+func wsReply(req wsMessage, subscribed map[string]bool) wsMessage {
+	if req.Type == wsError {
+		return req
+	}
+	if req.Type != wsSubscribe && req.Type != wsUnsubscribe {
+		return wsMessage{Type: wsError, Error: "unknown message type, expected subscribe or unsubscribe"}
+	}
+	if len(req.Events) == 0 {
+		return wsMessage{Type: wsError, Error: "events are required"}
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(wsEventTypes, e) {
+			return wsMessage{Type: wsError, Error: "unknown event " + e + ", expected order or balance"}
+		}
+	}
+
+	for _, e := range req.Events {
+		subscribed[e] = req.Type == wsSubscribe
+	}
+	reply := wsMessage{Type: wsSubscribed, Events: []string{}}
+	for _, e := range wsEventTypes {
+		if subscribed[e] {
+			reply.Events = append(reply.Events, e)
+		}
+	}
+	return reply
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	accrualclient "github.com/KirillZiborov/go-loyalty-program/internal/accrualClient"
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDegraded = "degraded"
+	StatusUnknown  = "unknown"
+)
+
+const checkTimeout = 2 * time.Second
+
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+type StatusReport struct {
+	Status        string                     `json:"status"`
+	ShuttingDown  bool                       `json:"shutting_down"`
+	AccrualPoller accrualclient.PollerStatus `json:"accrual_poller"`
+}
+
+type Checker struct {
+	db           *pgxpool.Pool
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(db *pgxpool.Pool) *Checker {
+	return &Checker{db: db}
+}
+
+// SetShuttingDown makes readiness fail so that the orchestrator stops routing traffic here.
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Liveness reports that the process is alive and serving HTTP.
+func (c *Checker) Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+	}
+}
+
+// Readiness checks the database and its schema. The accrual system is reported
+// but only degrades readiness, as the API keeps working while it is unavailable.
+func (c *Checker) Readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+
+		code := http.StatusOK
+		if report.Status == StatusFailing {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	}
+}
+
+// Status reports the accrual poller's last runs and backlog.
+func (c *Checker) Status() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+		writeJSON(w, http.StatusOK, StatusReport{
+			Status:        report.Status,
+			ShuttingDown:  c.shuttingDown.Load(),
+			AccrualPoller: accrualclient.Status(),
+		})
+	}
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Check)}
+	fail := func(name string, err error) {
+		report.Checks[name] = Check{Status: StatusFailing, Error: err.Error()}
+		report.Status = StatusFailing
+	}
+
+	if c.shuttingDown.Load() {
+		report.Checks["shutdown"] = Check{Status: StatusFailing, Error: "server is shutting down"}
+		report.Status = StatusFailing
+	}
+
+	if err := c.db.Ping(ctx); err != nil {
+		fail("database", err)
+	} else {
+		report.Checks["database"] = Check{Status: StatusOK}
+
+		if err := database.CheckMigrations(ctx, c.db); err != nil {
+			fail("migrations", err)
+		} else {
+			report.Checks["migrations"] = Check{Status: StatusOK}
+		}
+	}
+
+	poller := accrualclient.Status()
+	switch {
+	case poller.AccrualReachable == nil:
+		report.Checks["accrual"] = Check{Status: StatusUnknown}
+	case *poller.AccrualReachable:
+		report.Checks["accrual"] = Check{Status: StatusOK}
+	default:
+		report.Checks["accrual"] = Check{Status: StatusDegraded, Error: "accrual system is unreachable"}
+		if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+
+	return report
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
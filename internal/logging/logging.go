@@ -1,24 +1,151 @@
 package logging
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var Sugar zap.SugaredLogger
 
-func Initialize() error {
-	logger, err := zap.NewDevelopment()
-	if err != nil {
+// Poller is a sampled logger for the high-volume accrual poller.
+var Poller zap.SugaredLogger
+
+// Level controls the level of every logger and can be changed at runtime.
+var Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+const RequestIDHeader = "X-Request-ID"
+
+func init() {
+	Sugar = *zap.NewNop().Sugar()
+	Poller = Sugar
+}
+
+// Initialize builds the loggers. format is "json" for production encoding or "console".
+func Initialize(level, format string) error {
+	if err := SetLevel(level); err != nil {
 		return err
 	}
 
+	cfg := zap.NewProductionConfig()
+	if format == "console" {
+		cfg = zap.NewDevelopmentConfig()
+	}
+	cfg.Level = Level
+	cfg.Sampling = nil
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return err
+	}
 	Sugar = *logger.Sugar()
+
+	sampled := logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, 10, 100)
+	}))
+	Poller = *sampled.Sugar().With("component", "accrual")
 	return nil
 }
 
+// SetLevel changes the level of every logger.
+func SetLevel(level string) error {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Level.SetLevel(lvl)
+	return nil
+}
+
+// StdLogger returns a standard library logger writing to Sugar at error level.
+func StdLogger() *log.Logger {
+	logger, err := zap.NewStdLogAt(Sugar.Desugar(), zap.ErrorLevel)
+	if err != nil {
+		return log.Default()
+	}
+	return logger
+}
+
+type loggerKey struct{}
+type requestIDKey struct{}
+
+// contextLogger is shared by everything handling a request, so fields added
+// downstream (e.g. user ID after authentication) are visible to later log calls.
+type contextLogger struct {
+	mu     sync.RWMutex
+	logger *zap.SugaredLogger
+}
+
+// NewContext returns ctx carrying logger.
+func NewContext(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, &contextLogger{logger: logger})
+}
+
+// FromContext returns the request-scoped logger, or the global one if ctx carries none.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if cl, ok := ctx.Value(loggerKey{}).(*contextLogger); ok {
+		cl.mu.RLock()
+		defer cl.mu.RUnlock()
+		return cl.logger
+	}
+	return &Sugar
+}
+
+// AddFields attaches key-value pairs to the logger carried by ctx.
+func AddFields(ctx context.Context, args ...interface{}) {
+	if cl, ok := ctx.Value(loggerKey{}).(*contextLogger); ok {
+		cl.mu.Lock()
+		cl.logger = cl.logger.With(args...)
+		cl.mu.Unlock()
+	}
+}
+
+func WithUserID(ctx context.Context, userID int) {
+	AddFields(ctx, "user_id", userID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware propagates X-Request-ID or generates a new one and puts
+// a logger carrying it into the request context.
+func RequestIDMiddleware() func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if requestID == "" || len(requestID) > 128 {
+				requestID = NewRequestID()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			h.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+		})
+	}
+}
+
+// WithRequestID returns ctx carrying requestID and a logger tagged with it.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	return NewContext(ctx, Sugar.With("request_id", requestID))
+}
+
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func LoggingMiddleware() func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +163,8 @@ func LoggingMiddleware() func(h http.Handler) http.Handler {
 
 			duration := time.Since(start)
 
-			Sugar.Infoln(
+			FromContext(r.Context()).Infow(
+				"Request handled",
 				"uri", r.RequestURI,
 				"method", r.Method,
 				"status", responseData.status,
@@ -73,3 +201,21 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 	r.responseData.status = statusCode // захватываем код статуса
 }
+
+func (r *loggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.responseData.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
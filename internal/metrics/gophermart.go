@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var Default = NewRegistry()
+
+var (
+	HTTPRequests = NewCounter("gophermart_http_requests_total",
+		"Total number of HTTP requests by route, method and status.", "route", "method", "status")
+	HTTPDuration = NewHistogram("gophermart_http_request_duration_seconds",
+		"HTTP request latency by route, method and status.", nil, "route", "method", "status")
+
+	AccrualPendingOrders = NewGauge("gophermart_accrual_pending_orders",
+		"Number of orders waiting for accrual at the start of the last poll.")
+	AccrualBatchDuration = NewHistogram("gophermart_accrual_batch_duration_seconds",
+		"Duration of processing a batch of pending orders.", []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120})
+	AccrualRequests = NewCounter("gophermart_accrual_requests_total",
+		"Requests to the accrual system by response status code.", "code")
+	AccrualRequestDuration = NewHistogram("gophermart_accrual_request_duration_seconds",
+		"Latency of requests to the accrual system by response status code.", nil, "code")
+	AccrualThrottled = NewCounter("gophermart_accrual_throttled_total",
+		"Number of 429 Too Many Requests responses from the accrual system.")
+	AccrualRetries = NewCounter("gophermart_accrual_retries_total",
+		"Number of retried requests to the accrual system.")
+
+	PointsAccrued = NewCounter("gophermart_points_accrued_total",
+		"Loyalty points credited by the accrual system.")
+	PointsWithdrawn = NewCounter("gophermart_points_withdrawn_total",
+		"Loyalty points withdrawn by users.")
+
+	EventStreams = NewGauge("gophermart_event_streams",
+		"Number of open event streams by transport.", "transport")
+
+	ConfigReloads = NewCounter("gophermart_config_reloads_total",
+		"Configuration reload attempts by result.", "result")
+	ConfigLastReload = NewGauge("gophermart_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful configuration reload.")
+)
+
+func init() {
+	Default.Register(
+		HTTPRequests, HTTPDuration,
+		AccrualPendingOrders, AccrualBatchDuration, AccrualRequests, AccrualRequestDuration, AccrualThrottled, AccrualRetries,
+		PointsAccrued, PointsWithdrawn,
+		EventStreams,
+		ConfigReloads, ConfigLastReload,
+	)
+}
+
+// RegisterPool exposes pgx connection pool statistics.
+func RegisterPool(db *pgxpool.Pool) {
+	Default.Register(
+		NewGaugeFunc("gophermart_db_pool_total_conns", "Total number of connections in the pool.",
+			func() float64 { return float64(db.Stat().TotalConns()) }),
+		NewGaugeFunc("gophermart_db_pool_acquired_conns", "Number of currently acquired connections.",
+			func() float64 { return float64(db.Stat().AcquiredConns()) }),
+		NewGaugeFunc("gophermart_db_pool_idle_conns", "Number of currently idle connections.",
+			func() float64 { return float64(db.Stat().IdleConns()) }),
+		NewGaugeFunc("gophermart_db_pool_max_conns", "Maximum size of the pool.",
+			func() float64 { return float64(db.Stat().MaxConns()) }),
+		NewCounterFunc("gophermart_db_pool_acquire_total", "Cumulative count of successful acquires.",
+			func() float64 { return float64(db.Stat().AcquireCount()) }),
+		NewCounterFunc("gophermart_db_pool_acquire_duration_seconds_total", "Total time spent waiting for connections.",
+			func() float64 { return db.Stat().AcquireDuration().Seconds() }),
+		NewCounterFunc("gophermart_db_pool_empty_acquire_total", "Acquires that had to wait for a connection.",
+			func() float64 { return float64(db.Stat().EmptyAcquireCount()) }),
+		NewCounterFunc("gophermart_db_pool_canceled_acquire_total", "Acquires canceled by their context.",
+			func() float64 { return float64(db.Stat().CanceledAcquireCount()) }),
+	)
+}
+
+// Middleware records request count and latency labeled with the chi route pattern.
+func Middleware() func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+			h.ServeHTTP(sw, r)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			status := strconv.Itoa(sw.status)
+			HTTPRequests.Inc(route, r.Method, status)
+			HTTPDuration.Observe(time.Since(start).Seconds(), route, r.Method, status)
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(statusCode int) {
+	if !s.wroteHeader {
+		s.status = statusCode
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades pass through, the request is recorded as 101 Switching Protocols.
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && !s.wroteHeader {
+		s.status = http.StatusSwitchingProtocols
+		s.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -1,26 +1,86 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
-	ID       int    `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	ID           int    `json:"id"`
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code,omitempty"`
+	// SignupIP is the address the user registered from, empty if unknown.
+	SignupIP string `json:"-"`
 }
 
 type Order struct {
+	ID          int
 	UserID      int
+	MerchantID  int
 	OrderNumber string
 	Status      string
 	Accrual     *float32
 	UploadedAt  time.Time
+	Merchant    MerchantBrand
+	// AccrualAddress is the merchant's accrual system, empty for the configured default.
+	AccrualAddress string
 }
 
+const (
+	OrderStatusNew        = "NEW"
+	OrderStatusProcessing = "PROCESSING"
+	OrderStatusInvalid    = "INVALID"
+	OrderStatusProcessed  = "PROCESSED"
+)
+
 type OrderResponse struct {
-	OrderNumber string  `json:"number"`
-	Status      string  `json:"status"`
-	Accrual     float32 `json:"accrual,omitempty"`
-	UploadedAt  string  `json:"uploaded_at"`
+	OrderNumber string         `json:"number"`
+	Status      string         `json:"status"`
+	Accrual     float32        `json:"accrual,omitempty"`
+	UploadedAt  string         `json:"uploaded_at"`
+	Merchant    *MerchantBrand `json:"merchant,omitempty"`
+}
+
+type Merchant struct {
+	ID             int       `json:"-"`
+	Code           string    `json:"code"`
+	Name           string    `json:"name"`
+	AccrualAddress string    `json:"accrual_address,omitempty"`
+	LogoURL        string    `json:"logo_url,omitempty"`
+	Color          string    `json:"color,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// MerchantBrand is how a merchant is presented next to its orders.
+type MerchantBrand struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	LogoURL string `json:"logo_url,omitempty"`
+	Color   string `json:"color,omitempty"`
+}
+
+type MerchantRequest struct {
+	Code           string `json:"code"`
+	Name           string `json:"name"`
+	AccrualAddress string `json:"accrual_address"`
+	LogoURL        string `json:"logo_url"`
+	Color          string `json:"color"`
+}
+
+const (
+	OrderResultAccepted       = "accepted"
+	OrderResultDuplicateOwn   = "duplicate-own"
+	OrderResultDuplicateOther = "duplicate-other"
+	OrderResultInvalid        = "invalid"
+)
+
+// OrderResult is the outcome of a single number in a batch upload.
+type OrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	// Reason explains why an invalid number was rejected.
+	Reason string `json:"reason,omitempty"`
 }
 
 type Balance struct {
@@ -36,10 +96,181 @@ type BalanceResponse struct {
 type WithdrawRequest struct {
 	OrderNumber string  `json:"order"`
 	Sum         float32 `json:"sum"`
+	// Merchant is the code of the merchant the order was placed with, empty for the default one.
+	Merchant string `json:"merchant,omitempty"`
+	// OrderValue is the total of the order, it caps the share payable with points.
+	OrderValue float32 `json:"order_value,omitempty"`
+	// Quote is a token from a quote, whose held points guarantee the withdrawal.
+	Quote string `json:"quote,omitempty"`
 }
 
+type QuoteRequest struct {
+	OrderValue float32 `json:"order_value"`
+	// Merchant is the code of the merchant the order is placed with, empty for the default one.
+	Merchant string `json:"merchant,omitempty"`
+}
+
+// Quote is the amount redeemable on an order. Token is empty when nothing can be redeemed.
+type Quote struct {
+	Token      string     `json:"token,omitempty"`
+	Redeemable float32    `json:"redeemable"`
+	OrderValue float32    `json:"order_value"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+const (
+	QuoteStatusActive  = "ACTIVE"
+	QuoteStatusUsed    = "USED"
+	QuoteStatusExpired = "EXPIRED"
+)
+
 type Withdrawal struct {
-	OrderNumber string    `json:"order"`
-	Sum         float32   `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at"`
+	ID          int      `json:"id"`
+	OrderNumber string   `json:"order"`
+	Sum         float32  `json:"sum"`
+	OrderValue  *float32 `json:"order_value,omitempty"`
+	Status      string   `json:"status"`
+	// ExpiresAt is when a pending withdrawal is released unless confirmed.
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ProcessedAt time.Time  `json:"processed_at"`
+}
+
+const (
+	WithdrawalStatusPending   = "PENDING"
+	WithdrawalStatusConfirmed = "CONFIRMED"
+	WithdrawalStatusCancelled = "CANCELLED"
+	WithdrawalStatusExpired   = "EXPIRED"
+)
+
+type Campaign struct {
+	ID          int                 `json:"id"`
+	Name        string              `json:"name"`
+	StartsAt    time.Time           `json:"starts_at"`
+	EndsAt      time.Time           `json:"ends_at"`
+	Status      string              `json:"status"`
+	Eligibility CampaignEligibility `json:"eligibility"`
+	Bonus       CampaignBonus       `json:"bonus"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// CampaignEligibility is the predicate an order must satisfy to receive a campaign bonus.
+// Empty fields do not restrict eligibility.
+type CampaignEligibility struct {
+	FirstOrderOnly bool     `json:"first_order_only,omitempty"`
+	MinAccrual     float32  `json:"min_accrual,omitempty"`
+	Weekdays       []string `json:"weekdays,omitempty"`
+	// Merchants lists the codes of the merchants whose orders qualify.
+	Merchants []string `json:"merchants,omitempty"`
+}
+
+// CampaignBonus is the bonus formula: accrual * (multiplier - 1) + fixed, capped by max when set.
+type CampaignBonus struct {
+	Multiplier float32 `json:"multiplier,omitempty"`
+	Fixed      float32 `json:"fixed,omitempty"`
+	Max        float32 `json:"max,omitempty"`
+}
+
+type CampaignRequest struct {
+	Name        string              `json:"name"`
+	StartsAt    time.Time           `json:"starts_at"`
+	EndsAt      time.Time           `json:"ends_at"`
+	Eligibility CampaignEligibility `json:"eligibility"`
+	Bonus       CampaignBonus       `json:"bonus"`
+}
+
+// OrderFacts describes a processed order for campaign evaluation.
+type OrderFacts struct {
+	UserID      int
+	MerchantID  int
+	Merchant    string
+	OrderNumber string
+	Accrual     float32
+	FirstOrder  bool
+	ProcessedAt time.Time
+}
+
+type CampaignPreviewItem struct {
+	OrderNumber string  `json:"order"`
+	UserID      int     `json:"user_id"`
+	Accrual     float32 `json:"accrual"`
+	Bonus       float32 `json:"bonus"`
+	ProcessedAt string  `json:"processed_at"`
+}
+
+type CampaignPreview struct {
+	CampaignID    int                   `json:"campaign_id"`
+	From          string                `json:"from"`
+	To            string                `json:"to"`
+	OrdersMatched int                   `json:"orders_matched"`
+	UsersAffected int                   `json:"users_affected"`
+	TotalBonus    float32               `json:"total_bonus"`
+	Items         []CampaignPreviewItem `json:"items"`
+}
+
+type TransferRequest struct {
+	To  string  `json:"to"`
+	Sum float32 `json:"sum"`
+}
+
+type Transfer struct {
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Sum          float32   `json:"sum"`
+	ProcessedAt  time.Time `json:"processed_at"`
+}
+
+// ReferralProgram configures the reward a referrer receives once the referred
+// user's first order is processed. Cap limits rewarded referrals per referrer, 0 means unlimited.
+type ReferralProgram struct {
+	Bonus float32
+	Cap   int
+}
+
+type Referral struct {
+	Login        string     `json:"login"`
+	RegisteredAt time.Time  `json:"registered_at"`
+	Reward       *float32   `json:"reward,omitempty"`
+	RewardedAt   *time.Time `json:"rewarded_at,omitempty"`
+}
+
+type ReferralsResponse struct {
+	Code        string     `json:"code"`
+	TotalEarned float32    `json:"total_earned"`
+	Invited     []Referral `json:"invited"`
+}
+
+const (
+	EntryAccrual     = "ACCRUAL"
+	EntryBonus       = "BONUS"
+	EntryReferral    = "REFERRAL"
+	EntryTransferIn  = "TRANSFER_IN"
+	EntryTransferOut = "TRANSFER_OUT"
+	EntryWithdrawal  = "WITHDRAWAL"
+	EntryHold        = "HOLD"
+	EntryRelease     = "RELEASE"
+)
+
+// StatementEntry is a single balance movement. Amount is negative for debits,
+// Balance is the running balance after the entry.
+type StatementEntry struct {
+	Date      time.Time
+	Type      string
+	Reference string
+	Amount    float32
+	Balance   float32
+}
+
+const (
+	EventOrder   = "order"
+	EventBalance = "balance"
+)
+
+// Event is a change pushed to the user's event stream. IDs increase per user.
+// Data is the JSON encoded OrderResponse or BalanceResponse, depending on Type.
+type Event struct {
+	ID        int64           `json:"id"`
+	UserID    int             `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
 }
@@ -0,0 +1,133 @@
+// Package openapi describes the user API as an OpenAPI 3.1 document built from
+// the request and response models, and validates requests against it.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+)
+
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type Operation struct {
+	Method      string                `json:"-"`
+	Path        string                `json:"-"`
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+var components = map[string]*Schema{
+	"Problem": SchemaOf(response.Problem{}),
+}
+
+var (
+	buildOnce sync.Once
+	document  *Document
+	encoded   []byte
+)
+
+// Spec returns the API document.
+func Spec() *Document {
+	buildOnce.Do(func() {
+		document = &Document{
+			OpenAPI: "3.1.0",
+			Info:    Info{Title: "Gophermart loyalty system", Version: "1.0.0"},
+			Paths:   make(map[string]map[string]Operation),
+			Components: Components{
+				Schemas: components,
+				SecuritySchemes: map[string]SecurityScheme{
+					"cookieAuth": {Type: "apiKey", In: "cookie", Name: "cookie"},
+				},
+			},
+		}
+		for _, op := range operations {
+			if document.Paths[op.Path] == nil {
+				document.Paths[op.Path] = make(map[string]Operation)
+			}
+			document.Paths[op.Path][methodKey(op.Method)] = op
+		}
+		encoded, _ = json.MarshalIndent(document, "", "  ")
+	})
+	return document
+}
+
+// Handler serves the API document as JSON.
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Spec()
+		w.Header().Set("Content-Type", response.ContentTypeJSON)
+		w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(encoded)
+	}
+}
+
+// find returns the operation for method and chi route pattern.
+func find(method, pattern string) *Operation {
+	ops, ok := Spec().Paths[pattern]
+	if !ok {
+		return nil
+	}
+	op, ok := ops[methodKey(method)]
+	if !ok {
+		return nil
+	}
+	return &op
+}
+
+func methodKey(method string) string {
+	return strings.ToLower(method)
+}
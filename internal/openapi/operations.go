@@ -0,0 +1,371 @@
+package openapi
+
+import (
+	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/KirillZiborov/go-loyalty-program/internal/statement"
+)
+
+var cookieAuth = []map[string][]string{{"cookieAuth": {}}}
+
+var orderStatuses = []string{models.OrderStatusNew, models.OrderStatusProcessing,
+	models.OrderStatusInvalid, models.OrderStatusProcessed}
+
+var withdrawalStatuses = []string{models.WithdrawalStatusPending, models.WithdrawalStatusConfirmed,
+	models.WithdrawalStatusCancelled, models.WithdrawalStatusExpired}
+
+// operations lists every /api/user route. main checks at startup that the router
+// serves exactly these routes.
+var operations = []Operation{
+	{
+		Method: "POST", Path: "/api/user/register", OperationID: "registerUser",
+		Summary:     "Register a user and authenticate them",
+		RequestBody: jsonBody(SchemaOf(models.User{}).Omit("id").NonEmpty("login", "password")),
+		Responses: responses(
+			message("200", "User registered and authenticated"),
+			problem("400", "Invalid request or referral code"),
+			problem("409", "Login is already taken"),
+			problem("413", "Request body too large"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/login", OperationID: "loginUser",
+		Summary:     "Authenticate a user",
+		RequestBody: jsonBody(SchemaOf(models.User{}).Omit("id", "referral_code").NonEmpty("login", "password")),
+		Responses: responses(
+			message("200", "User authenticated"),
+			problem("400", "Invalid request"),
+			problem("401", "Invalid login or password"),
+			problem("413", "Request body too large"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/orders", OperationID: "submitOrder",
+		Summary:    "Upload an order number for accrual",
+		Security:   cookieAuth,
+		Parameters: []Parameter{merchantParameter()},
+		RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+			"text/plain": {Schema: &Schema{Type: "string", Description: "Order number in the merchant's format"}},
+		}},
+		Responses: responses(
+			message("200", "Order was already uploaded by this user"),
+			empty("202", "Order accepted for processing"),
+			problem("400", "Invalid request or unknown merchant"),
+			problem("401", "User is not authenticated"),
+			problem("409", "Order was already uploaded by another user"),
+			problem("413", "Request body too large"),
+			problem("422", "Invalid order number"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/orders/batch", OperationID: "submitOrders",
+		Summary: "Upload several order numbers for accrual",
+		Description: "The body is a JSON array of order numbers or one number per line as text/plain. " +
+			"The response lists the result of every number in the order they were sent. " +
+			"The batch size is limited by the order_batch_limit setting.",
+		Security:   cookieAuth,
+		Parameters: []Parameter{merchantParameter()},
+		RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+			response.ContentTypeJSON: {Schema: &Schema{Type: "array", Items: &Schema{Type: "string"}}},
+			"text/plain":             {Schema: &Schema{Type: "string"}},
+		}},
+		Responses: responses(
+			ok("200", "Result of every order number", []models.OrderResult{}, func(s *Schema) {
+				s.Items.OneOf("result", models.OrderResultAccepted, models.OrderResultDuplicateOwn,
+					models.OrderResultDuplicateOther, models.OrderResultInvalid)
+			}),
+			problem("400", "Invalid request, unknown merchant or too many order numbers"),
+			problem("401", "User is not authenticated"),
+			problem("413", "Request body too large"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/orders", OperationID: "getOrders",
+		Summary:     "List uploaded orders, newest first",
+		Description: "Orders of every merchant are listed together, each names its merchant.",
+		Security:    cookieAuth,
+		Parameters:  append(pageParameters(), statusParameter(orderStatuses)),
+		Responses: responses(
+			ok("200", "Orders", []models.OrderResponse{}, func(s *Schema) { s.Items.OneOf("status", orderStatuses...) }),
+			empty("204", "No orders"),
+			problem("400", "Invalid query parameters"),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/orders/stream", OperationID: "streamEvents",
+		Summary: "Stream order status and balance changes as Server-Sent Events",
+		Description: "Events named order carry an order, events named balance carry the balance. " +
+			"Reconnecting clients send Last-Event-ID to receive the events they missed.",
+		Security: cookieAuth,
+		Parameters: []Parameter{
+			{Name: "Last-Event-ID", In: "header", Description: "Id of the last event received",
+				Schema: &Schema{Type: "string", Pattern: "^[0-9]+$"}},
+		},
+		Responses: responses(
+			Response{Description: "Event stream", Content: map[string]MediaType{
+				"text/event-stream": {Schema: &Schema{Type: "string"}},
+			}}.status("200"),
+			problem("400", "Invalid Last-Event-ID header"),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/ws", OperationID: "openWebSocket",
+		Summary: "Receive order status and balance changes over a WebSocket",
+		Description: "Messages are JSON objects with a type. Clients send " +
+			`{"type":"subscribe","events":["order","balance"]} or unsubscribe, ` +
+			"the server answers with subscribed listing the current subscriptions or error, and sends " +
+			`{"type":"event","id":1,"event":"order","data":{...}} for every change. ` +
+			"Slow clients are disconnected with close code 1013.",
+		Security: cookieAuth,
+		Responses: responses(
+			empty("101", "Switching to the WebSocket protocol"),
+			problem("400", "Not a WebSocket handshake"),
+			problem("401", "User is not authenticated"),
+			problem("403", "Origin is not allowed"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/balance", OperationID: "getBalance",
+		Summary: "Get the current balance and the total withdrawn",
+		Description: "The balance is shared by all merchants: points accrued on orders of any merchant " +
+			"can be withdrawn on orders of any other.",
+		Security: cookieAuth,
+		Responses: responses(
+			ok("200", "Balance", models.BalanceResponse{}, nil),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/balance/withdraw", OperationID: "withdraw",
+		Summary: "Spend points on an order",
+		Description: "Withdrawals must respect the redemption rules: the redemption_min and redemption_max amounts, " +
+			"at most redemption_max_percent of order_value when it is given and, with redemption_once_per_order, " +
+			"a single withdrawal per order number and merchant. merchant is the code of the merchant the order was " +
+			"placed with, the default merchant if omitted. With a quote token for the same merchant the points held " +
+			"by the quote are spent, up to the quoted amount, and the rest is returned to the balance.",
+		Security:    cookieAuth,
+		RequestBody: jsonBody(SchemaOf(models.WithdrawRequest{}).Positive("sum")),
+		Responses: responses(
+			empty("200", "Points withdrawn"),
+			problem("400", "Invalid request or unknown merchant"),
+			problem("401", "User is not authenticated"),
+			problem("402", "Insufficient funds"),
+			problem("404", "Quote not found"),
+			problem("409", "Order was already paid with points, or the quote expired or was used"),
+			problem("413", "Request body too large"),
+			problem("422", "Invalid order number, redemption amount outside the allowed bounds or above the quote"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/balance/quote", OperationID: "quote",
+		Summary: "Quote the points redeemable on an order",
+		Description: "Returns how many points can be redeemed on an order of order_value under the redemption rules " +
+			"and the current balance. The points are held until expires_at and a withdrawal passing the token as " +
+			"quote for the same merchant is guaranteed up to that amount. No token is returned when nothing can be redeemed.",
+		Security:    cookieAuth,
+		RequestBody: jsonBody(SchemaOf(models.QuoteRequest{}).Positive("order_value")),
+		Responses: responses(
+			ok("200", "Quote", models.Quote{}, nil),
+			problem("400", "Invalid request or unknown merchant"),
+			problem("401", "User is not authenticated"),
+			problem("413", "Request body too large"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/balance/transfer", OperationID: "transfer",
+		Summary:     "Transfer points to another user",
+		Security:    cookieAuth,
+		RequestBody: jsonBody(SchemaOf(models.TransferRequest{}).NonEmpty("to").Positive("sum")),
+		Responses: responses(
+			empty("200", "Points transferred"),
+			problem("400", "Invalid request or transfer to self"),
+			problem("401", "User is not authenticated"),
+			problem("402", "Insufficient funds"),
+			problem("404", "Recipient not found"),
+			problem("413", "Request body too large"),
+			problem("422", "Daily transfer limit exceeded"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/withdrawals", OperationID: "getWithdrawals",
+		Summary:    "List withdrawals, newest first",
+		Security:   cookieAuth,
+		Parameters: append(pageParameters(), statusParameter(withdrawalStatuses)),
+		Responses: responses(
+			ok("200", "Withdrawals", []models.Withdrawal{}, func(s *Schema) { s.Items.OneOf("status", withdrawalStatuses...) }),
+			empty("204", "No withdrawals"),
+			problem("400", "Invalid query parameters"),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "POST", Path: "/api/user/withdrawals", OperationID: "reserveWithdrawal",
+		Summary: "Reserve points for an order",
+		Description: "Takes the points off the balance and creates a PENDING withdrawal. It has to be confirmed " +
+			"before expires_at, otherwise the points are returned to the balance. The redemption rules of " +
+			"/api/user/balance/withdraw apply, including quote tokens.",
+		Security:    cookieAuth,
+		RequestBody: jsonBody(SchemaOf(models.WithdrawRequest{}).Positive("sum")),
+		Responses: responses(
+			ok("201", "Pending withdrawal", models.Withdrawal{}, func(s *Schema) { s.OneOf("status", withdrawalStatuses...) }),
+			problem("400", "Invalid request or unknown merchant"),
+			problem("401", "User is not authenticated"),
+			problem("402", "Insufficient funds"),
+			problem("404", "Quote not found"),
+			problem("409", "Order was already paid with points, or the quote expired or was used"),
+			problem("413", "Request body too large"),
+			problem("422", "Invalid order number, redemption amount outside the allowed bounds or above the quote"),
+			problem("500", "Internal server error"),
+		),
+	},
+	resolveWithdrawalOperation("confirm", "confirmWithdrawal", "Confirm a pending withdrawal"),
+	resolveWithdrawalOperation("cancel", "cancelWithdrawal", "Cancel a pending withdrawal and return the points"),
+	{
+		Method: "GET", Path: "/api/user/transfers", OperationID: "getTransfers",
+		Summary:  "List incoming and outgoing transfers",
+		Security: cookieAuth,
+		Responses: responses(
+			ok("200", "Transfers", []models.Transfer{}, func(s *Schema) { s.Items.OneOf("direction", "IN", "OUT") }),
+			empty("204", "No transfers"),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/referrals", OperationID: "getReferrals",
+		Summary:  "Get the referral code and invited users",
+		Security: cookieAuth,
+		Responses: responses(
+			ok("200", "Referrals", models.ReferralsResponse{}, nil),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+	{
+		Method: "GET", Path: "/api/user/statement", OperationID: "getStatement",
+		Summary:  "Export the account statement",
+		Security: cookieAuth,
+		Parameters: []Parameter{
+			{Name: "from", In: "query", Description: "Start of the period, inclusive", Schema: &Schema{Type: "string", Format: "date-time"}},
+			{Name: "to", In: "query", Description: "End of the period, exclusive", Schema: &Schema{Type: "string", Format: "date-time"}},
+			{Name: "format", In: "query", Schema: &Schema{Type: "string", Enum: []string{statement.FormatCSV, statement.FormatJSONL, statement.FormatText}}},
+		},
+		Responses: responses(
+			Response{Description: "Statement", Content: map[string]MediaType{
+				statement.ContentType(statement.FormatCSV):   {Schema: &Schema{Type: "string"}},
+				statement.ContentType(statement.FormatJSONL): {Schema: &Schema{Type: "string"}},
+				statement.ContentType(statement.FormatText):  {Schema: &Schema{Type: "string"}},
+			}}.status("200"),
+			problem("400", "Invalid query parameters"),
+			problem("401", "User is not authenticated"),
+			problem("500", "Internal server error"),
+		),
+	},
+}
+
+func pageParameters() []Parameter {
+	minLimit, maxLimit := 1.0, float64(pagination.MaxLimit)
+	return []Parameter{
+		{Name: "limit", In: "query", Description: "Page size",
+			Schema: &Schema{Type: "integer", Minimum: &minLimit, Maximum: &maxLimit}},
+		{Name: "cursor", In: "query", Description: "Cursor from the X-Next-Cursor header of the previous page",
+			Schema: &Schema{Type: "string"}},
+		{Name: "from", In: "query", Schema: &Schema{Type: "string", Format: "date-time"}},
+		{Name: "to", In: "query", Schema: &Schema{Type: "string", Format: "date-time"}},
+	}
+}
+
+func merchantParameter() Parameter {
+	return Parameter{Name: "merchant", In: "query",
+		Description: "Code of the merchant the order was placed with, the default merchant if omitted. Order numbers are unique per merchant and checked against its format",
+		Schema:      &Schema{Type: "string"}}
+}
+
+func resolveWithdrawalOperation(action, id, summary string) Operation {
+	return Operation{
+		Method: "POST", Path: "/api/user/withdrawals/{id}/" + action, OperationID: id,
+		Summary:  summary,
+		Security: cookieAuth,
+		Parameters: []Parameter{{Name: "id", In: "path", Required: true,
+			Description: "Withdrawal id", Schema: &Schema{Type: "integer"}}},
+		Responses: responses(
+			ok("200", "Withdrawal", models.Withdrawal{}, func(s *Schema) { s.OneOf("status", withdrawalStatuses...) }),
+			problem("400", "Invalid withdrawal id"),
+			problem("401", "User is not authenticated"),
+			problem("404", "Withdrawal not found"),
+			problem("409", "Withdrawal is no longer pending or its reservation expired"),
+			problem("500", "Internal server error"),
+		),
+	}
+}
+
+func statusParameter(statuses []string) Parameter {
+	return Parameter{Name: "status", In: "query",
+		Description: "Comma separated statuses to include: " + strings.Join(statuses, ", "),
+		Schema:      &Schema{Type: "string"}}
+}
+
+func jsonBody(s *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{
+		response.ContentTypeJSON: {Schema: s},
+	}}
+}
+
+type statusResponse struct {
+	code     string
+	response Response
+}
+
+func (r Response) status(code string) statusResponse {
+	return statusResponse{code: code, response: r}
+}
+
+func responses(rs ...statusResponse) map[string]Response {
+	m := make(map[string]Response, len(rs))
+	for _, r := range rs {
+		m[r.code] = r.response
+	}
+	return m
+}
+
+func ok(code, description string, v any, adjust func(*Schema)) statusResponse {
+	s := SchemaOf(v)
+	if adjust != nil {
+		adjust(s)
+	}
+	return Response{Description: description, Content: map[string]MediaType{
+		response.ContentTypeJSON: {Schema: s},
+	}}.status(code)
+}
+
+func message(code, description string) statusResponse {
+	return ok(code, description, struct {
+		Message string `json:"message"`
+	}{}, nil)
+}
+
+func empty(code, description string) statusResponse {
+	return Response{Description: description}.status(code)
+}
+
+func problem(code, description string) statusResponse {
+	return Response{Description: description, Content: map[string]MediaType{
+		response.ContentTypeProblem: {Schema: ref("Problem")},
+	}}.status(code)
+}
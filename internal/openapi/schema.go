@@ -0,0 +1,243 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+)
+
+// Schema is the subset of JSON Schema used by the API document.
+type Schema struct {
+	Ref              string             `json:"$ref,omitempty"`
+	Type             string             `json:"type,omitempty"`
+	Format           string             `json:"format,omitempty"`
+	Description      string             `json:"description,omitempty"`
+	Enum             []string           `json:"enum,omitempty"`
+	Pattern          string             `json:"pattern,omitempty"`
+	Minimum          *float64           `json:"minimum,omitempty"`
+	Maximum          *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64           `json:"exclusiveMinimum,omitempty"`
+	MinLength        *int               `json:"minLength,omitempty"`
+	Items            *Schema            `json:"items,omitempty"`
+	Properties       map[string]*Schema `json:"properties,omitempty"`
+	Required         []string           `json:"required,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf derives a schema from the JSON encoding of v. Struct fields without
+// omitempty are required.
+func SchemaOf(v any) *Schema {
+	return schemaOfType(reflect.TypeOf(v))
+}
+
+func schemaOfType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOfType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaOfType(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	return &Schema{}
+}
+
+// Omit removes properties from an object schema.
+func (s *Schema) Omit(names ...string) *Schema {
+	for _, name := range names {
+		delete(s.Properties, name)
+		s.Required = slices.DeleteFunc(s.Required, func(r string) bool { return r == name })
+	}
+	return s
+}
+
+// NonEmpty requires string properties to have at least one character.
+func (s *Schema) NonEmpty(names ...string) *Schema {
+	one := 1
+	for _, name := range names {
+		s.Properties[name].MinLength = &one
+	}
+	return s
+}
+
+// Positive requires numeric properties to be greater than zero.
+func (s *Schema) Positive(names ...string) *Schema {
+	zero := 0.0
+	for _, name := range names {
+		s.Properties[name].ExclusiveMinimum = &zero
+	}
+	return s
+}
+
+// OneOf restricts a string property to values.
+func (s *Schema) OneOf(name string, values ...string) *Schema {
+	s.Properties[name].Enum = values
+	return s
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// validate checks a value decoded with json.Decoder.UseNumber against s and
+// returns the violations keyed by their path.
+func (s *Schema) validate(path string, v any) []response.FieldError {
+	if s.Ref != "" {
+		return components[strings.TrimPrefix(s.Ref, "#/components/schemas/")].validate(path, v)
+	}
+	fail := func(format string, args ...any) []response.FieldError {
+		field := path
+		if field == "" {
+			field = "body"
+		}
+		return []response.FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fail("must be an object")
+		}
+		var errs []response.FieldError
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, response.FieldError{Field: join(path, name), Message: "is required"})
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := s.Properties[name]; ok && value != nil {
+				errs = append(errs, prop.validate(join(path, name), value)...)
+			}
+		}
+		return errs
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fail("must be an array")
+		}
+		var errs []response.FieldError
+		for i, item := range arr {
+			errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+		return errs
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		return s.validateString(str, fail)
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fail("must be a number")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fail("must be a number")
+		}
+		return s.validateNumber(f, fail)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(str string, fail func(string, ...any) []response.FieldError) []response.FieldError {
+	if s.MinLength != nil && len(str) < *s.MinLength {
+		return fail("must not be empty")
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+		return fail("must be one of %s", strings.Join(s.Enum, ", "))
+	}
+	if s.Pattern != "" && !matchPattern(s.Pattern, str) {
+		return fail("must match %s", s.Pattern)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return fail("must be an RFC 3339 timestamp")
+		}
+	}
+	return nil
+}
+
+// patterns caches the compiled schema patterns, which come from the document and always compile.
+var patterns sync.Map
+
+func matchPattern(pattern, str string) bool {
+	re, ok := patterns.Load(pattern)
+	if !ok {
+		re, _ = patterns.LoadOrStore(pattern, regexp.MustCompile(pattern))
+	}
+	return re.(*regexp.Regexp).MatchString(str)
+}
+
+func (s *Schema) validateNumber(f float64, fail func(string, ...any) []response.FieldError) []response.FieldError {
+	if s.Type == "integer" && f != math.Trunc(f) {
+		return fail("must be an integer")
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		return fail("must be at least %v", *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		return fail("must be at most %v", *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		return fail("must be greater than %v", *s.ExclusiveMinimum)
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
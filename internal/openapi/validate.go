@@ -0,0 +1,248 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+	"github.com/go-chi/chi"
+)
+
+// ValidateResponses enables checking responses against the document. Mismatches
+// are logged, never sent to the client.
+var ValidateResponses bool
+
+// maxValidatedResponse limits how much of a response body is buffered for validation.
+const maxValidatedResponse = 1 << 20
+
+// Validate rejects requests whose parameters or JSON body do not match the
+// operation documented for the matched route. Routes without an operation are not checked.
+func Validate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		op := find(r.Method, rctx.RoutePattern())
+		if op == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		errs := validateParameters(op, r)
+		if op.RequestBody != nil {
+			// Operations accepting several media types only have JSON bodies checked.
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			s, ok := op.RequestBody.Content[response.ContentTypeJSON]
+			if ok && (len(op.RequestBody.Content) == 1 || mediaType == response.ContentTypeJSON) {
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					response.BodyError(w, r, err)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(data))
+
+				dec := json.NewDecoder(bytes.NewReader(data))
+				dec.UseNumber()
+				var body any
+				if err := dec.Decode(&body); err != nil {
+					response.Error(w, r, http.StatusBadRequest, response.CodeInvalidInput, "Request body is not valid JSON")
+					return
+				}
+				errs = append(errs, s.Schema.validate("", body)...)
+			}
+		}
+		if len(errs) > 0 {
+			slices.SortFunc(errs, func(a, b response.FieldError) int { return strings.Compare(a.Field, b.Field) })
+			response.Error(w, r, http.StatusBadRequest, response.CodeValidationFailed, "Request does not match the API schema", errs...)
+			return
+		}
+
+		if !ValidateResponses {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rw, r)
+		if err := checkResponse(op, rw.status, rw.Header(), rw.body.Bytes(), !rw.truncated); err != nil {
+			logging.FromContext(r.Context()).Warnw("Response does not match the API document",
+				"operation", op.OperationID, "status", rw.status, "error", err)
+		}
+	}
+}
+
+func validateParameters(op *Operation, r *http.Request) []response.FieldError {
+	q := r.URL.Query()
+	var errs []response.FieldError
+	for _, p := range op.Parameters {
+		var v string
+		switch p.In {
+		case "query":
+			v = q.Get(p.Name)
+		case "header":
+			v = r.Header.Get(p.Name)
+		case "path":
+			v = chi.URLParam(r, p.Name)
+		}
+		if v == "" {
+			if p.Required {
+				errs = append(errs, response.FieldError{Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		var value any = v
+		if p.Schema.Type == "integer" || p.Schema.Type == "number" {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				errs = append(errs, response.FieldError{Field: p.Name, Message: "must be a number"})
+				continue
+			}
+			value = json.Number(v)
+		}
+		errs = append(errs, p.Schema.validate(p.Name, value)...)
+	}
+	return errs
+}
+
+// CheckResponse returns an error if a response with status, header and body is
+// not documented for the operation served at method and route pattern.
+func CheckResponse(method, pattern string, status int, header http.Header, body []byte) error {
+	op := find(method, pattern)
+	if op == nil {
+		return fmt.Errorf("%s %s is not documented", method, pattern)
+	}
+	return checkResponse(op, status, header, body, true)
+}
+
+// checkResponse checks the response against op. Incomplete bodies are only
+// checked for their presence.
+func checkResponse(op *Operation, status int, header http.Header, body []byte, complete bool) error {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("status %d has an undocumented body", status)
+		}
+		return nil
+	}
+	content, ok := resp.Content[header.Get("Content-Type")]
+	if !ok {
+		content, ok = resp.Content[mediaType]
+	}
+	if !ok && mediaType == response.ContentTypeJSON {
+		// Problems are sent as application/json to clients that do not accept problem+json.
+		content, ok = resp.Content[response.ContentTypeProblem]
+	}
+	if !ok {
+		return fmt.Errorf("content type %q is not documented for status %d", header.Get("Content-Type"), status)
+	}
+	if (mediaType != response.ContentTypeJSON && mediaType != response.ContentTypeProblem) || !complete {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+	if errs := content.Schema.validate("", v); len(errs) > 0 {
+		problems := make([]string, len(errs))
+		for i, e := range errs {
+			problems[i] = e.Field + " " + e.Message
+		}
+		slices.Sort(problems)
+		return fmt.Errorf("body does not match the schema: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// recordingWriter keeps the status and a copy of the body for validation.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	truncated   bool
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	if !rw.wroteHeader {
+		rw.status = statusCode
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.body.Len()+len(b) <= maxValidatedResponse {
+		rw.body.Write(b)
+	} else {
+		rw.truncated = true
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil && !rw.wroteHeader {
+		rw.status = http.StatusSwitchingProtocols
+		rw.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Check verifies that the router serves exactly the /api/user operations of the document.
+func Check(routes chi.Routes) error {
+	served := make(map[string]bool)
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/api/user/") {
+			served[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, op := range operations {
+		key := op.Method + " " + op.Path
+		if !served[key] {
+			problems = append(problems, "documented but not served: "+key)
+		}
+		delete(served, key)
+	}
+	for key := range served {
+		problems = append(problems, "served but not documented: "+key)
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("router does not match the API document: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
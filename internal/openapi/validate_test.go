@@ -0,0 +1,102 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestValidate(t *testing.T) {
+	reached := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	r := chi.NewRouter()
+	r.Post("/api/user/balance/withdraw", Validate(reached))
+	r.Get("/api/user/orders/stream", Validate(reached))
+	r.Get("/api/user/orders", Validate(reached))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+		body   string
+		want   int
+	}{
+		{
+			name: "empty order number is left to the handler", method: http.MethodPost, path: "/api/user/balance/withdraw",
+			body: `{"order": "", "sum": 10}`, want: http.StatusTeapot,
+		},
+		{
+			name: "non-positive sum", method: http.MethodPost, path: "/api/user/balance/withdraw",
+			body: `{"order": "2377225624", "sum": 0}`, want: http.StatusBadRequest,
+		},
+		{
+			name: "missing sum", method: http.MethodPost, path: "/api/user/balance/withdraw",
+			body: `{"order": "2377225624"}`, want: http.StatusBadRequest,
+		},
+		{
+			name: "numeric Last-Event-ID", method: http.MethodGet, path: "/api/user/orders/stream",
+			header: map[string]string{"Last-Event-ID": "42"}, want: http.StatusTeapot,
+		},
+		{
+			name: "Last-Event-ID not matching the pattern", method: http.MethodGet, path: "/api/user/orders/stream",
+			header: map[string]string{"Last-Event-ID": "4x"}, want: http.StatusBadRequest,
+		},
+		{
+			name: "limit out of range", method: http.MethodGet, path: "/api/user/orders?limit=0", want: http.StatusBadRequest,
+		},
+		{
+			name: "timestamp query", method: http.MethodGet, path: "/api/user/orders?from=2024-01-02T03:04:05Z", want: http.StatusTeapot,
+		},
+		{
+			name: "invalid timestamp query", method: http.MethodGet, path: "/api/user/orders?from=yesterday", want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		body    string
+		wantErr bool
+	}{
+		{name: "documented body", status: http.StatusOK, header: jsonHeader, body: `{"current": 1.5, "withdrawn": 0}`},
+		{name: "missing property", status: http.StatusOK, header: jsonHeader, body: `{"current": 1.5}`, wantErr: true},
+		{name: "wrong type", status: http.StatusOK, header: jsonHeader, body: `{"current": "1.5", "withdrawn": 0}`, wantErr: true},
+		{name: "undocumented status", status: http.StatusTeapot, header: jsonHeader, body: `{}`, wantErr: true},
+		{name: "undocumented content type", status: http.StatusOK, header: http.Header{"Content-Type": {"text/plain"}}, body: "1.5", wantErr: true},
+		{name: "problem as JSON", status: http.StatusUnauthorized, header: jsonHeader,
+			body: `{"type": "about:blank", "title": "Unauthorized", "status": 401, "code": "unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckResponse(http.MethodGet, "/api/user/balance", tt.status, tt.header, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckResponse = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
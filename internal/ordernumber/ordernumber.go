@@ -0,0 +1,209 @@
+// Package ordernumber validates order numbers. Merchants whose receipts use
+// other formats get their own validation profile.
+package ordernumber
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Error describes why a number was rejected.
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "order number " + e.Reason
+}
+
+var (
+	ErrorEmpty     = &Error{Reason: "must not be empty"}
+	ErrorTooShort  = &Error{Reason: "is too short"}
+	ErrorTooLong   = &Error{Reason: "is too long"}
+	ErrorNotDigits = &Error{Reason: "must contain only digits"}
+	ErrorPrefix    = &Error{Reason: "has an unexpected prefix"}
+	ErrorChecksum  = &Error{Reason: "has an invalid check digit"}
+)
+
+type Validator interface {
+	// Validate returns nil if number is acceptable and an *Error otherwise.
+	Validate(number string) error
+}
+
+// Luhn accepts non-empty digit strings with a valid Luhn check digit.
+type Luhn struct{}
+
+func (Luhn) Validate(number string) error {
+	if number == "" {
+		return ErrorEmpty
+	}
+	var sum int
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			return ErrorNotDigits
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	if sum%10 != 0 {
+		return ErrorChecksum
+	}
+	return nil
+}
+
+// Digits accepts non-empty digit strings.
+type Digits struct{}
+
+func (Digits) Validate(number string) error {
+	if number == "" {
+		return ErrorEmpty
+	}
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return ErrorNotDigits
+		}
+	}
+	return nil
+}
+
+// Length bounds the length of a number, 0 leaves a bound open.
+type Length struct {
+	Min int
+	Max int
+}
+
+func (l Length) Validate(number string) error {
+	switch {
+	case number == "":
+		return ErrorEmpty
+	case l.Min > 0 && len(number) < l.Min:
+		return ErrorTooShort
+	case l.Max > 0 && len(number) > l.Max:
+		return ErrorTooLong
+	}
+	return nil
+}
+
+// Prefix accepts numbers starting with one of the prefixes.
+type Prefix []string
+
+func (p Prefix) Validate(number string) error {
+	for _, prefix := range p {
+		if strings.HasPrefix(number, prefix) {
+			return nil
+		}
+	}
+	return ErrorPrefix
+}
+
+// All accepts numbers accepted by every validator and returns the first error otherwise.
+type All []Validator
+
+func (a All) Validate(number string) error {
+	for _, v := range a {
+		if err := v.Validate(number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	ChecksumLuhn = "luhn"
+	ChecksumNone = "none"
+)
+
+// DefaultMaxLength bounds numbers of profiles that do not set max_length.
+const DefaultMaxLength = 32
+
+// Profile is the configured receipt format of a merchant.
+type Profile struct {
+	// Checksum is luhn (the default) or none.
+	Checksum  string   `json:"checksum,omitempty"`
+	MinLength int      `json:"min_length,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Prefixes  []string `json:"prefixes,omitempty"`
+}
+
+// Validator builds the validator described by p.
+func (p Profile) Validator() (Validator, error) {
+	maxLength := p.MaxLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxLength
+	}
+	if p.MinLength < 0 || maxLength < 0 || p.MinLength > maxLength {
+		return nil, fmt.Errorf("invalid length bounds %d..%d", p.MinLength, maxLength)
+	}
+
+	v := All{Length{Min: p.MinLength, Max: maxLength}}
+	if len(p.Prefixes) > 0 {
+		if slices.Contains(p.Prefixes, "") {
+			return nil, fmt.Errorf("empty prefix")
+		}
+		v = append(v, Prefix(p.Prefixes))
+	}
+	switch p.Checksum {
+	case "", ChecksumLuhn:
+		v = append(v, Luhn{})
+	case ChecksumNone:
+		v = append(v, Digits{})
+	default:
+		return nil, fmt.Errorf("unknown checksum %q, expected luhn or none", p.Checksum)
+	}
+	return v, nil
+}
+
+// DefaultProfile is used for orders not submitted on behalf of a merchant.
+const DefaultProfile = "default"
+
+// Profiles maps merchant codes to their validators.
+type Profiles struct {
+	validators map[string]Validator
+}
+
+// NewProfiles builds the validators of the profiles. The default profile is
+// Luhn with at most DefaultMaxLength digits unless profiles overrides it.
+func NewProfiles(profiles map[string]Profile) (*Profiles, error) {
+	p := &Profiles{validators: map[string]Validator{DefaultProfile: All{Length{Max: DefaultMaxLength}, Luhn{}}}}
+	for name, profile := range profiles {
+		v, err := profile.Validator()
+		if err != nil {
+			return nil, fmt.Errorf("order number profile %s: %w", name, err)
+		}
+		p.validators[name] = v
+	}
+	return p, nil
+}
+
+// ParseProfiles builds profiles from their JSON encoding, an object keyed by
+// merchant code. An empty string yields only the default profile.
+func ParseProfiles(s string) (*Profiles, error) {
+	profiles := make(map[string]Profile)
+	if strings.TrimSpace(s) != "" {
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&profiles); err != nil {
+			return nil, fmt.Errorf("invalid order number profiles: %w", err)
+		}
+	}
+	return NewProfiles(profiles)
+}
+
+// For returns the validator of the merchant, or of the default profile if the
+// merchant is empty or has no profile of its own.
+func (p *Profiles) For(merchant string) Validator {
+	if v, ok := p.validators[merchant]; ok {
+		return v
+	}
+	return p.validators[DefaultProfile]
+}
@@ -0,0 +1,136 @@
+package ordernumber
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// referenceLuhn is an independent Luhn check: digits are read left to right
+// and every second digit counted from the right is replaced by its doubled digit sum.
+func referenceLuhn(number string) bool {
+	if number == "" {
+		return false
+	}
+	doubled := [10]int{0, 2, 4, 6, 8, 1, 3, 5, 7, 9}
+	parity := len(number) % 2
+	sum := 0
+	for i, r := range number {
+		if r < '0' || r > '9' {
+			return false
+		}
+		d := int(r - '0')
+		if i%2 == parity {
+			d = doubled[d]
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func allDigits(number string) bool {
+	return number != "" && strings.Trim(number, "0123456789") == ""
+}
+
+// checkRejection fails if err is not an *Error when reject is set, or not nil otherwise.
+func checkRejection(t *testing.T, number string, err error, reject bool) {
+	t.Helper()
+	var reason *Error
+	switch {
+	case reject && !errors.As(err, &reason):
+		t.Fatalf("Validate(%q) = %v, want an *Error", number, err)
+	case !reject && err != nil:
+		t.Fatalf("Validate(%q) = %v, want nil", number, err)
+	}
+}
+
+var seeds = []string{"", "0", "12345678903", "12345678901", "2377225624", "4561261212345467",
+	"1234abcd", " 12345678903", "١٢٣", strings.Repeat("0", DefaultMaxLength+1), strings.Repeat("9", 100)}
+
+func FuzzLuhn(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, number string) {
+		checkRejection(t, number, Luhn{}.Validate(number), !referenceLuhn(number))
+	})
+}
+
+func FuzzDigits(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, number string) {
+		checkRejection(t, number, Digits{}.Validate(number), !allDigits(number))
+	})
+}
+
+func FuzzLength(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s, 0, DefaultMaxLength)
+		f.Add(s, 5, 10)
+	}
+	f.Fuzz(func(t *testing.T, number string, minLength, maxLength int) {
+		l := Length{Min: minLength, Max: maxLength}
+		tooShort := minLength > 0 && len(number) < minLength
+		tooLong := maxLength > 0 && len(number) > maxLength
+		checkRejection(t, number, l.Validate(number), number == "" || tooShort || tooLong)
+	})
+}
+
+func FuzzPrefix(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s, "12", "4")
+	}
+	f.Fuzz(func(t *testing.T, number, a, b string) {
+		if a == "" || b == "" {
+			// An empty prefix matches everything, profiles refuse it.
+			return
+		}
+		hasPrefix := strings.HasPrefix(number, a) || strings.HasPrefix(number, b)
+		checkRejection(t, number, Prefix{a, b}.Validate(number), number == "" || !hasPrefix)
+	})
+}
+
+func FuzzProfile(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s, true, 0, 0, "")
+		f.Add(s, false, 4, 12, "12")
+	}
+	f.Fuzz(func(t *testing.T, number string, luhn bool, minLength, maxLength int, prefix string) {
+		p := Profile{Checksum: ChecksumNone, MinLength: minLength, MaxLength: maxLength}
+		if luhn {
+			p.Checksum = ChecksumLuhn
+		}
+		if prefix != "" {
+			p.Prefixes = []string{prefix}
+		}
+		profiles, err := NewProfiles(map[string]Profile{"shop": p})
+		if err != nil {
+			return
+		}
+		v := profiles.For("shop")
+
+		if maxLength == 0 {
+			maxLength = DefaultMaxLength
+		}
+		valid := len(number) >= minLength && len(number) <= maxLength && strings.HasPrefix(number, prefix)
+		if luhn {
+			valid = valid && referenceLuhn(number)
+		} else {
+			valid = valid && allDigits(number)
+		}
+		checkRejection(t, number, v.Validate(number), !valid)
+
+		checkRejection(t, "", v.Validate(""), true)
+		if maxLength < 1<<16 {
+			overlong := strings.Repeat("0", maxLength+1)
+			checkRejection(t, overlong, v.Validate(overlong), true)
+		}
+
+		// Merchants without a profile of their own get the default one.
+		def := profiles.For("other")
+		defValid := len(number) <= DefaultMaxLength && referenceLuhn(number)
+		checkRejection(t, number, def.Validate(number), !defValid)
+	})
+}
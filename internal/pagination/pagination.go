@@ -0,0 +1,136 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var ErrorInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at the last row of a page ordered by (time, id) descending.
+type Cursor struct {
+	Time time.Time
+	ID   int
+}
+
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.Time.UnixMicro(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func Decode(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrorInvalidCursor
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+	return &Cursor{Time: time.UnixMicro(us).UTC(), ID: n}, nil
+}
+
+// Params are the keyset pagination and filter parameters of a listing request.
+type Params struct {
+	Limit    int
+	After    *Cursor
+	Statuses []string
+	From     *time.Time
+	To       *time.Time
+}
+
+// Parse reads limit, cursor, status, from and to query parameters.
+// Statuses are accepted only if present in allowedStatuses.
+func Parse(r *http.Request, allowedStatuses ...string) (*Params, error) {
+	q := r.URL.Query()
+	p := &Params{Limit: DefaultLimit}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := Decode(v)
+		if err != nil {
+			return nil, err
+		}
+		p.After = cursor
+	}
+
+	for _, v := range q["status"] {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if status == "" {
+				continue
+			}
+			if !slices.Contains(allowedStatuses, status) {
+				return nil, fmt.Errorf("unknown status %q", status)
+			}
+			p.Statuses = append(p.Statuses, status)
+		}
+	}
+
+	var err error
+	if p.From, err = parseTime(q.Get("from")); err != nil {
+		return nil, fmt.Errorf("invalid from parameter: %w", err)
+	}
+	if p.To, err = parseTime(q.Get("to")); err != nil {
+		return nil, fmt.Errorf("invalid to parameter: %w", err)
+	}
+	if p.From != nil && p.To != nil && !p.To.After(*p.From) {
+		return nil, errors.New("to must be after from")
+	}
+
+	return p, nil
+}
+
+// SetNext advertises the next page via the Link and X-Next-Cursor headers.
+func SetNext(w http.ResponseWriter, r *http.Request, next *Cursor) {
+	if next == nil {
+		return
+	}
+	encoded := next.Encode()
+
+	q := r.URL.Query()
+	q.Set("cursor", encoded)
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.String()))
+	w.Header().Set("X-Next-Cursor", encoded)
+}
+
+func parseTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	t = t.UTC()
+	return &t, nil
+}
@@ -0,0 +1,147 @@
+// Package response writes JSON bodies and RFC 7807 problem details shared by all API handlers.
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+)
+
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeProblem = "application/problem+json"
+)
+
+// Machine-readable error codes carried in Problem.Code.
+const (
+	CodeInvalidInput        = "invalid_input"
+	CodeValidationFailed    = "validation_failed"
+	CodeBodyTooLarge        = "body_too_large"
+	CodeInvalidQuery        = "invalid_query"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeLoginTaken          = "login_taken"
+	CodeInvalidReferralCode = "invalid_referral_code"
+	CodeSelfReferral        = "self_referral"
+	CodeInvalidOrderNumber  = "invalid_order_number"
+	CodeUnknownMerchant     = "unknown_merchant"
+	CodeOrderConflict       = "order_conflict"
+	CodeInsufficientFunds   = "insufficient_funds"
+	CodeOrderRedeemed       = "order_already_redeemed"
+	CodeRedemptionBelowMin  = "redemption_below_minimum"
+	CodeRedemptionAboveMax  = "redemption_above_maximum"
+	CodeRedemptionShare     = "redemption_share_exceeded"
+	CodeWithdrawalNotFound  = "withdrawal_not_found"
+	CodeWithdrawalResolved  = "withdrawal_not_pending"
+	CodeWithdrawalExpired   = "withdrawal_expired"
+	CodeQuoteNotFound       = "quote_not_found"
+	CodeQuoteExpired        = "quote_expired"
+	CodeQuoteExceeded       = "quote_exceeded"
+	CodeRecipientNotFound   = "recipient_not_found"
+	CodeSelfTransfer        = "self_transfer"
+	CodeTransferLimit       = "transfer_limit_exceeded"
+	CodeCampaignNotFound    = "campaign_not_found"
+	CodeMerchantExists      = "merchant_exists"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeInternal            = "internal_error"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object. Code, RequestID and Errors are extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// JSON writes v as a JSON response.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Message writes a JSON response of the form {"message": "..."}.
+func Message(w http.ResponseWriter, status int, message string) {
+	JSON(w, status, map[string]string{"message": message})
+}
+
+// Error writes a problem details response. Clients that accept application/json
+// but not application/problem+json get the same body as application/json.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string, fields ...FieldError) {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: logging.RequestIDFromContext(r.Context()),
+		Errors:    fields,
+	}
+
+	contentType := ContentTypeProblem
+	if !accepts(r, ContentTypeProblem) && accepts(r, ContentTypeJSON) {
+		contentType = ContentTypeJSON
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusUnauthorized, CodeUnauthorized, "Authentication required")
+}
+
+func Internal(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// NotFound and MethodNotAllowed are the router's fallback handlers.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
+
+// BodyError reports a request body that could not be read or decoded: 413 if it
+// exceeded its size limit and 400 otherwise.
+func BodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		Error(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "Request body too large")
+		return
+	}
+	Error(w, r, http.StatusBadRequest, CodeInvalidInput, "Invalid request body")
+}
+
+// accepts reports whether the Accept header of r explicitly lists mediaType.
+// Wildcards are not considered, so the default wins for clients accepting anything.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mt != mediaType {
+			continue
+		}
+		return params["q"] != "0"
+	}
+	return false
+}
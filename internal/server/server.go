@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/response"
+)
+
+// Timeouts of the HTTP server. Zero values disable the corresponding timeout.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+func New(addr string, h http.Handler, t Timeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+		ErrorLog:          logging.StdLogger(),
+	}
+}
+
+type bodyLimitKey struct{}
+
+// MaxBodySize limits the size of the request body read by h to n bytes.
+// The limit is also kept in the request context for BodyLimit.
+func MaxBodySize(n int64, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, n)))
+	}
+}
+
+// BodyLimit returns the body size limit set by MaxBodySize, 0 if there is none.
+func BodyLimit(ctx context.Context) int64 {
+	n, _ := ctx.Value(bodyLimitKey{}).(int64)
+	return n
+}
+
+// RecoveryMiddleware turns a panic in a handler into a logged stack trace and a 500 response.
+// If the handler has already started the response, the connection is aborted instead.
+func RecoveryMiddleware() func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := &trackingWriter{ResponseWriter: w}
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				logging.FromContext(r.Context()).Errorw("Panic while handling request",
+					"panic", rec,
+					"stack", string(debug.Stack()),
+					"responseStarted", tw.started,
+				)
+
+				if tw.started {
+					panic(http.ErrAbortHandler)
+				}
+				response.Internal(w, r)
+			}()
+
+			h.ServeHTTP(tw, r)
+		})
+	}
+}
+
+// trackingWriter records whether the response has been started.
+type trackingWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (tw *trackingWriter) WriteHeader(statusCode int) {
+	tw.started = true
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *trackingWriter) Write(b []byte) (int, error) {
+	tw.started = true
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *trackingWriter) Flush() {
+	tw.started = true
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.started = true
+	return http.NewResponseController(tw.ResponseWriter).Hijack()
+}
+
+func (tw *trackingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
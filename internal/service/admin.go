@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/campaigns"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+)
+
+func (s *LoyaltyService) CreateCampaign(ctx context.Context, req *models.CampaignRequest) (*models.Campaign, error) {
+	if err := campaigns.Validate(req); err != nil {
+		return nil, &ValidationError{Message: err.Error()}
+	}
+	return s.store.CreateCampaign(ctx, req)
+}
+
+func (s *LoyaltyService) PauseCampaign(ctx context.Context, id int) (*models.Campaign, error) {
+	return s.store.SetCampaignStatus(ctx, id, campaigns.StatusPaused)
+}
+
+// ResumeCampaign makes a paused campaign apply to processed orders again.
+func (s *LoyaltyService) ResumeCampaign(ctx context.Context, id int) (*models.Campaign, error) {
+	return s.store.SetCampaignStatus(ctx, id, campaigns.StatusActive)
+}
+
+// PreviewCampaign evaluates the campaign against orders processed in the
+// [from, to) range, zero times default to the campaign's own date range.
+// Orders get the bonus they would get if processed now, so a paused campaign
+// and orders outside the campaign's date range match nothing.
+func (s *LoyaltyService) PreviewCampaign(ctx context.Context, id int, from, to time.Time) (*models.CampaignPreview, error) {
+	campaign, err := s.store.GetCampaign(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		from = campaign.StartsAt
+	}
+	if to.IsZero() {
+		to = campaign.EndsAt
+	}
+
+	facts, err := s.store.GetProcessedOrderFacts(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.CampaignPreview{
+		CampaignID: campaign.ID,
+		From:       from.Format(time.RFC3339),
+		To:         to.Format(time.RFC3339),
+		Items:      []models.CampaignPreviewItem{},
+	}
+	users := make(map[int]struct{})
+	for _, f := range facts {
+		bonus := campaigns.Evaluate(campaign, f)
+		if bonus <= 0 {
+			continue
+		}
+		users[f.UserID] = struct{}{}
+		result.TotalBonus += bonus
+		result.Items = append(result.Items, models.CampaignPreviewItem{
+			OrderNumber: f.OrderNumber,
+			UserID:      f.UserID,
+			Accrual:     f.Accrual,
+			Bonus:       bonus,
+			ProcessedAt: f.ProcessedAt.Format(time.RFC3339),
+		})
+	}
+	result.OrdersMatched = len(result.Items)
+	result.UsersAffected = len(users)
+	return result, nil
+}
+
+var merchantCode = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+var merchantColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func validateMerchant(req *models.MerchantRequest) error {
+	var fields []FieldError
+	if !merchantCode.MatchString(req.Code) {
+		fields = append(fields, FieldError{Field: "code", Message: "must be 1-32 lowercase letters, digits or dashes"})
+	}
+	if req.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Message: "is required"})
+	}
+	urls := []struct{ field, value string }{{"accrual_address", req.AccrualAddress}, {"logo_url", req.LogoURL}}
+	for _, f := range urls {
+		if f.value == "" {
+			continue
+		}
+		if u, err := url.Parse(f.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields = append(fields, FieldError{Field: f.field, Message: "must be an http(s) URL"})
+		}
+	}
+	if req.Color != "" && !merchantColor.MatchString(req.Color) {
+		fields = append(fields, FieldError{Field: "color", Message: "must be a #rrggbb color"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Message: "Invalid merchant", Fields: fields}
+	}
+	return nil
+}
+
+// CreateMerchant registers a merchant. Orders are submitted for it with its code,
+// and an empty accrual_address keeps them on the configured accrual system.
+func (s *LoyaltyService) CreateMerchant(ctx context.Context, req *models.MerchantRequest) (*models.Merchant, error) {
+	if err := validateMerchant(req); err != nil {
+		return nil, err
+	}
+	return s.store.CreateMerchant(ctx, req)
+}
+
+func (s *LoyaltyService) ListMerchants(ctx context.Context) ([]models.Merchant, error) {
+	return s.store.GetMerchants(ctx)
+}
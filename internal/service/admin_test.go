@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/campaigns"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service/servicetest"
+)
+
+func TestResumeCampaign(t *testing.T) {
+	var got string
+	store := &servicetest.Store{
+		SetCampaignStatusFunc: func(_ context.Context, id int, status string) (*models.Campaign, error) {
+			got = status
+			return &models.Campaign{ID: id, Status: status}, nil
+		},
+	}
+	s := newTestService(t, store, Limits{})
+
+	if _, err := s.PauseCampaign(context.Background(), 1); err != nil || got != campaigns.StatusPaused {
+		t.Fatalf("PauseCampaign set status %q, %v, want %q", got, err, campaigns.StatusPaused)
+	}
+	if _, err := s.ResumeCampaign(context.Background(), 1); err != nil || got != campaigns.StatusActive {
+		t.Fatalf("ResumeCampaign set status %q, %v, want %q", got, err, campaigns.StatusActive)
+	}
+}
+
+// TestPreviewCampaign checks that the preview grants what processing the orders would.
+func TestPreviewCampaign(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	facts := []models.OrderFacts{
+		{UserID: 1, OrderNumber: "1", Accrual: 100, ProcessedAt: start.Add(-time.Hour)},
+		{UserID: 1, OrderNumber: "2", Accrual: 100, ProcessedAt: start.Add(time.Hour)},
+		{UserID: 2, OrderNumber: "3", Accrual: 50, ProcessedAt: start.Add(2 * time.Hour)},
+	}
+	tests := []struct {
+		status    string
+		wantBonus float32
+	}{
+		{status: campaigns.StatusActive, wantBonus: 150},
+		{status: campaigns.StatusPaused},
+	}
+	for _, tt := range tests {
+		campaign := &models.Campaign{ID: 1, StartsAt: start, EndsAt: start.Add(24 * time.Hour), Status: tt.status,
+			Bonus: models.CampaignBonus{Multiplier: 2}}
+		store := &servicetest.Store{
+			GetCampaignFunc:   func(context.Context, int) (*models.Campaign, error) { return campaign, nil },
+			GetOrderFactsFunc: func(context.Context, time.Time, time.Time) ([]models.OrderFacts, error) { return facts, nil },
+		}
+		s := newTestService(t, store, Limits{})
+
+		preview, err := s.PreviewCampaign(context.Background(), 1, start.Add(-24*time.Hour), start.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preview.TotalBonus != tt.wantBonus {
+			t.Errorf("preview of a %s campaign grants %v over %d orders, want %v",
+				tt.status, preview.TotalBonus, preview.OrdersMatched, tt.wantBonus)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+)
+
+var ErrorInvalidOrderNumber = errors.New("invalid order number format")
+var ErrorUnknownMerchant = errors.New("unknown merchant")
+var ErrorOrderConflict = errors.New("order already submitted by another user")
+var ErrorInvalidCredentials = errors.New("invalid login or password")
+var ErrorRedemptionBelowMin = errors.New("withdrawal is below the minimum redemption")
+var ErrorRedemptionAboveMax = errors.New("withdrawal is above the maximum redemption")
+var ErrorRedemptionShareExceeded = errors.New("withdrawal exceeds the share of the order value payable with points")
+
+// Storage errors callers need to tell apart, re-exported so front-ends only depend on this package.
+var (
+	ErrorLoginTaken           = database.ErrorDuplicate
+	ErrorInvalidReferralCode  = database.ErrorInvalidReferralCode
+	ErrorSelfReferral         = database.ErrorSelfReferral
+	ErrorInsufficientFunds    = database.ErrorInsufficientFunds
+	ErrorOrderRedeemed        = database.ErrorOrderRedeemed
+	ErrorWithdrawalNotFound   = database.ErrorWithdrawalNotFound
+	ErrorWithdrawalNotPending = database.ErrorWithdrawalNotPending
+	ErrorWithdrawalExpired    = database.ErrorWithdrawalExpired
+	ErrorQuoteNotFound        = database.ErrorQuoteNotFound
+	ErrorQuoteExpired         = database.ErrorQuoteExpired
+	ErrorQuoteExceeded        = database.ErrorQuoteExceeded
+	ErrorRecipientNotFound    = database.ErrorUserNotFound
+	ErrorSelfTransfer         = database.ErrorSelfTransfer
+	ErrorTransferLimitReached = database.ErrorTransferLimit
+	ErrorCampaignNotFound     = database.ErrorCampaignNotFound
+	ErrorMerchantExists       = database.ErrorMerchantExists
+)
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError reports malformed input, Fields lists the offending fields when known.
+type ValidationError struct {
+	Message string
+	Fields  []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// required takes name, value pairs and returns a ValidationError for the empty values, or nil.
+func required(message string, pairs ...string) error {
+	var fields []FieldError
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			fields = append(fields, FieldError{Field: pairs[i], Message: "is required"})
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: message, Fields: fields}
+}
@@ -0,0 +1,431 @@
+// Package service holds the loyalty program rules shared by the HTTP and gRPC front-ends.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/logging"
+	"github.com/KirillZiborov/go-loyalty-program/internal/metrics"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/KirillZiborov/go-loyalty-program/internal/tracing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type LoyaltyService struct {
+	store   Store
+	limits  Limits
+	numbers *ordernumber.Profiles
+}
+
+type Limits struct {
+	// TransferDaily caps the points a user can transfer per day, 0 disables it.
+	TransferDaily float32
+	// OrderBatch is the maximum number of orders in a batch upload.
+	OrderBatch int
+	// RejectSameIPReferrals refuses referral codes used from the address their owner signed up from.
+	// Sign-up addresses are only stored when it is set.
+	RejectSameIPReferrals bool
+	// SignupIPRetention is how long sign-up addresses are kept.
+	SignupIPRetention time.Duration
+	Redemption        Redemption
+}
+
+// Redemption holds the rules withdrawals must follow, zero values disable the bounds.
+type Redemption struct {
+	Min float32
+	Max float32
+	// MaxPercent is the share of the order value payable with points, checked when the value is known.
+	// With zero quotes offer up to the whole order value.
+	MaxPercent   float32
+	OncePerOrder bool
+	// Hold is how long a reserved withdrawal waits for confirmation.
+	Hold time.Duration
+	// QuoteTTL is how long a quote holds the redeemable points.
+	QuoteTTL time.Duration
+}
+
+// NewLoyaltyService returns a service backed by store. Order numbers are checked
+// against the profile of the merchant they are submitted for.
+func NewLoyaltyService(store Store, limits Limits, numbers *ordernumber.Profiles) *LoyaltyService {
+	return &LoyaltyService{store: store, limits: limits, numbers: numbers}
+}
+
+// merchant looks up the merchant by code, empty means the default merchant,
+// and returns it along with its order number validator.
+func (s *LoyaltyService) merchant(ctx context.Context, code string) (*models.Merchant, ordernumber.Validator, error) {
+	if code == "" {
+		code = database.DefaultMerchant
+	}
+	m, err := s.store.GetMerchantByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, database.ErrorMerchantNotFound) {
+			return nil, nil, ErrorUnknownMerchant
+		}
+		return nil, nil, err
+	}
+	return m, s.numbers.For(code), nil
+}
+
+func checkNumber(v ordernumber.Validator, number string) error {
+	if err := v.Validate(number); err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidOrderNumber, err)
+	}
+	return nil
+}
+
+// Register creates a user signing up from clientIP and returns its id.
+func (s *LoyaltyService) Register(ctx context.Context, login, password, referralCode, clientIP string) (int, error) {
+	if !s.limits.RejectSameIPReferrals {
+		clientIP = ""
+	}
+	if err := required("Login and password are required", "login", login, "password", password); err != nil {
+		return 0, err
+	}
+
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	span.End()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.store.CreateUser(ctx, &models.User{
+		Login:        login,
+		Password:     string(hashedPassword),
+		ReferralCode: referralCode,
+		SignupIP:     clientIP,
+	}, s.limits.RejectSameIPReferrals)
+}
+
+// Login checks the credentials and returns the user id.
+func (s *LoyaltyService) Login(ctx context.Context, login, password string) (int, error) {
+	if err := required("Login and password are required", "login", login, "password", password); err != nil {
+		return 0, err
+	}
+
+	user, err := s.store.GetUserByLogin(ctx, login)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, ErrorInvalidCredentials
+	}
+
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	span.End()
+	if err != nil {
+		return 0, ErrorInvalidCredentials
+	}
+	return user.ID, nil
+}
+
+// SubmitOrder registers the order for accrual. It reports false if the user has already submitted it.
+func (s *LoyaltyService) SubmitOrder(ctx context.Context, userID int, merchant, number string) (bool, error) {
+	m, v, err := s.merchant(ctx, merchant)
+	if err != nil {
+		return false, err
+	}
+	number = strings.TrimSpace(number)
+	if err := checkNumber(v, number); err != nil {
+		return false, err
+	}
+
+	exists, ownerID, err := s.store.OrderExists(ctx, m.ID, number)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if ownerID != userID {
+			return false, ErrorOrderConflict
+		}
+		return false, nil
+	}
+
+	if err := s.store.AddOrder(ctx, userID, m.ID, number); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SubmitOrders registers a batch of orders and returns the result of each number in input order.
+// A number repeated within the batch is reported as a duplicate of the user's own order.
+func (s *LoyaltyService) SubmitOrders(ctx context.Context, userID int, merchant string, numbers []string) ([]models.OrderResult, error) {
+	m, v, err := s.merchant(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) == 0 {
+		return nil, &ValidationError{Message: "At least one order number is required"}
+	}
+	if len(numbers) > s.limits.OrderBatch {
+		return nil, &ValidationError{Message: fmt.Sprintf("At most %d order numbers can be submitted at once", s.limits.OrderBatch)}
+	}
+
+	results := make([]models.OrderResult, len(numbers))
+	var valid []string
+	seen := make(map[string]bool)
+	for i, number := range numbers {
+		number = strings.TrimSpace(number)
+		results[i].Number = number
+		if err := v.Validate(number); err != nil {
+			results[i].Result = models.OrderResultInvalid
+			results[i].Reason = err.Error()
+			continue
+		}
+		if !seen[number] {
+			seen[number] = true
+			valid = append(valid, number)
+		}
+	}
+
+	accepted, owners, err := s.store.AddOrders(ctx, userID, m.ID, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		number := results[i].Number
+		switch {
+		case results[i].Result != "":
+		case accepted[number]:
+			results[i].Result = models.OrderResultAccepted
+			// Later copies of the number in the batch are the user's own duplicates.
+			delete(accepted, number)
+		case owners[number] == userID:
+			results[i].Result = models.OrderResultDuplicateOwn
+		default:
+			results[i].Result = models.OrderResultDuplicateOther
+		}
+	}
+	return results, nil
+}
+
+// ListOrders returns a page of the user's orders. Accrual is only set on processed orders.
+func (s *LoyaltyService) ListOrders(ctx context.Context, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+	orders, next, err := s.store.GetOrdersByUserID(ctx, userID, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range orders {
+		if orders[i].Status != models.OrderStatusProcessed {
+			orders[i].Accrual = nil
+		}
+	}
+	return orders, next, nil
+}
+
+func (s *LoyaltyService) Balance(ctx context.Context, userID int) (*models.Balance, error) {
+	return s.store.GetUserBalance(ctx, userID)
+}
+
+// Withdraw spends req.Sum points on the order. The order value is optional, a
+// quote token spends the points held by the quote.
+func (s *LoyaltyService) Withdraw(ctx context.Context, userID int, req models.WithdrawRequest) error {
+	m, v, err := s.merchant(ctx, req.Merchant)
+	if err != nil {
+		return err
+	}
+	value, err := s.checkRedemption(v, req.OrderNumber, req.Sum, req.OrderValue)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.WithdrawBalance(ctx, userID, m.ID, req.Sum, req.OrderNumber, value, s.limits.Redemption.OncePerOrder, req.Quote)
+	if err != nil {
+		return err
+	}
+	metrics.PointsWithdrawn.Add(float64(req.Sum))
+	return nil
+}
+
+// ReserveWithdrawal holds req.Sum points for the order until the withdrawal is
+// confirmed, cancelled or expires after the configured hold.
+func (s *LoyaltyService) ReserveWithdrawal(ctx context.Context, userID int, req models.WithdrawRequest) (*models.Withdrawal, error) {
+	m, v, err := s.merchant(ctx, req.Merchant)
+	if err != nil {
+		return nil, err
+	}
+	value, err := s.checkRedemption(v, req.OrderNumber, req.Sum, req.OrderValue)
+	if err != nil {
+		return nil, err
+	}
+	rules := s.limits.Redemption
+	return s.store.ReserveWithdrawal(ctx, userID, m.ID, req.Sum, req.OrderNumber, value, rules.OncePerOrder, rules.Hold, req.Quote)
+}
+
+// Quote works out how many points the user can redeem on an order of
+// orderValue with the merchant under the redemption rules and holds them for
+// the quote TTL. Only a withdrawal for the same merchant can claim the quote.
+func (s *LoyaltyService) Quote(ctx context.Context, userID int, merchant string, orderValue float32) (*models.Quote, error) {
+	if orderValue <= 0 {
+		return nil, &ValidationError{Message: "Invalid order value", Fields: []FieldError{{Field: "order_value", Message: "must be positive"}}}
+	}
+	m, _, err := s.merchant(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := s.limits.Redemption
+	percent := rules.MaxPercent
+	if percent == 0 {
+		percent = 100
+	}
+	// Work in whole cents, a float32 order value like 99.99 would otherwise lose a cent.
+	cents := math.Round(float64(orderValue) * 100)
+	limit := float32(math.Floor(cents*float64(percent)/100) / 100)
+	if rules.Max > 0 && limit > rules.Max {
+		limit = rules.Max
+	}
+
+	return s.store.CreateQuote(ctx, userID, m.ID, orderValue, limit, rules.Min, rules.QuoteTTL)
+}
+
+func (s *LoyaltyService) ConfirmWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	withdrawal, err := s.store.ConfirmWithdrawal(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	metrics.PointsWithdrawn.Add(float64(withdrawal.Sum))
+	return withdrawal, nil
+}
+
+func (s *LoyaltyService) CancelWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	return s.store.CancelWithdrawal(ctx, userID, id)
+}
+
+// PruneSignupIPs forgets sign-up addresses older than the retention every hour until ctx is done.
+func (s *LoyaltyService) PruneSignupIPs(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		cleared, err := s.store.ForgetSignupIPs(ctx, time.Now().Add(-s.limits.SignupIPRetention))
+		if err != nil {
+			logging.Sugar.Errorw("Error pruning sign-up addresses", "error", err)
+		} else if cleared > 0 {
+			logging.Sugar.Infow("Pruned sign-up addresses", "cleared", cleared)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReleaseExpired returns the points of expired pending withdrawals and quotes
+// to their users every interval until ctx is done.
+func (s *LoyaltyService) ReleaseExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		withdrawals, quotes, err := s.store.ExpireHolds(ctx)
+		if err != nil {
+			logging.Sugar.Errorw("Error releasing expired holds", "error", err)
+		} else if withdrawals > 0 || quotes > 0 {
+			logging.Sugar.Infow("Released expired holds", "withdrawals", withdrawals, "quotes", quotes)
+		}
+	}
+}
+
+// checkRedemption checks the order number with v, applies the redemption rules
+// and returns the order value to record, nil if unknown.
+func (s *LoyaltyService) checkRedemption(v ordernumber.Validator, orderNumber string, sum, orderValue float32) (*float32, error) {
+	if err := checkNumber(v, orderNumber); err != nil {
+		return nil, err
+	}
+	if sum <= 0 {
+		return nil, &ValidationError{Message: "Invalid amount", Fields: []FieldError{{Field: "sum", Message: "must be positive"}}}
+	}
+	if orderValue < 0 {
+		return nil, &ValidationError{Message: "Invalid order value", Fields: []FieldError{{Field: "order_value", Message: "must not be negative"}}}
+	}
+
+	rules := s.limits.Redemption
+	switch {
+	case rules.Min > 0 && sum < rules.Min:
+		return nil, fmt.Errorf("%w of %.2f", ErrorRedemptionBelowMin, rules.Min)
+	case rules.Max > 0 && sum > rules.Max:
+		return nil, fmt.Errorf("%w of %.2f", ErrorRedemptionAboveMax, rules.Max)
+	case orderValue > 0 && rules.MaxPercent > 0 && sum > orderValue*rules.MaxPercent/100:
+		return nil, fmt.Errorf("%w (%g%%)", ErrorRedemptionShareExceeded, rules.MaxPercent)
+	}
+
+	if orderValue > 0 {
+		return &orderValue, nil
+	}
+	return nil, nil
+}
+
+func (s *LoyaltyService) ListWithdrawals(ctx context.Context, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+	return s.store.GetUserWithdrawals(ctx, userID, params)
+}
+
+// Transfer moves sum points to the user with login to.
+func (s *LoyaltyService) Transfer(ctx context.Context, userID int, to string, sum float32) error {
+	if err := required("Recipient login is required", "to", to); err != nil {
+		return err
+	}
+	if sum <= 0 {
+		return &ValidationError{Message: "Invalid amount", Fields: []FieldError{{Field: "sum", Message: "must be positive"}}}
+	}
+	return s.store.TransferBalance(ctx, userID, to, sum, s.limits.TransferDaily)
+}
+
+func (s *LoyaltyService) ListTransfers(ctx context.Context, userID int) ([]models.Transfer, error) {
+	return s.store.GetUserTransfers(ctx, userID)
+}
+
+func (s *LoyaltyService) Referrals(ctx context.Context, userID int) (*models.ReferralsResponse, error) {
+	return s.store.GetUserReferrals(ctx, userID)
+}
+
+// OpeningBalance returns the user's balance at t, the start of a statement.
+func (s *LoyaltyService) OpeningBalance(ctx context.Context, userID int, t time.Time) (float32, error) {
+	return s.store.GetBalanceAt(ctx, userID, t)
+}
+
+// Statement calls fn with the user's balance changes in [from, to), oldest first,
+// and returns the closing balance.
+func (s *LoyaltyService) Statement(ctx context.Context, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error) {
+	return s.store.StreamStatement(ctx, userID, from, to, opening, fn)
+}
+
+// replayBatch is the number of stored events loaded per query when a stream resumes.
+const replayBatch = 500
+
+// EventsAfter calls fn with the user's stored events newer than afterID, oldest first.
+func (s *LoyaltyService) EventsAfter(ctx context.Context, userID int, afterID int64, fn func(models.Event) error) error {
+	for {
+		events, err := s.store.GetEventsAfter(ctx, userID, afterID, replayBatch)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+			afterID = event.ID
+		}
+		if len(events) < replayBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/ordernumber"
+	"github.com/KirillZiborov/go-loyalty-program/internal/service/servicetest"
+)
+
+// newTestService returns a service whose "plain" merchant takes any digits without a checksum.
+func newTestService(t *testing.T, store Store, limits Limits) *LoyaltyService {
+	t.Helper()
+	numbers, err := ordernumber.NewProfiles(map[string]ordernumber.Profile{"plain": {Checksum: ordernumber.ChecksumNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLoyaltyService(store, limits, numbers)
+}
+
+func TestCheckRedemption(t *testing.T) {
+	rules := Redemption{Min: 10, Max: 500, MaxPercent: 30}
+	tests := []struct {
+		name       string
+		rules      Redemption
+		merchant   string
+		number     string
+		sum        float32
+		orderValue float32
+		wantErr    error
+		wantValue  *float32
+	}{
+		{name: "within bounds", rules: rules, number: "12345678903", sum: 100},
+		{name: "order value recorded", rules: rules, number: "12345678903", sum: 30, orderValue: 100, wantValue: ptr(float32(100))},
+		{name: "at the minimum", rules: rules, number: "12345678903", sum: 10},
+		{name: "below the minimum", rules: rules, number: "12345678903", sum: 9.99, wantErr: ErrorRedemptionBelowMin},
+		{name: "at the maximum", rules: rules, number: "12345678903", sum: 500},
+		{name: "above the maximum", rules: rules, number: "12345678903", sum: 500.01, wantErr: ErrorRedemptionAboveMax},
+		{name: "at the percent cap", rules: rules, number: "12345678903", sum: 30, orderValue: 100, wantValue: ptr(float32(100))},
+		{name: "above the percent cap", rules: rules, number: "12345678903", sum: 31, orderValue: 100, wantErr: ErrorRedemptionShareExceeded},
+		{name: "percent cap needs the order value", rules: rules, number: "12345678903", sum: 400},
+		{name: "no bounds", number: "12345678903", sum: 1e6, orderValue: 1, wantValue: ptr(float32(1))},
+		{name: "zero sum", rules: rules, number: "12345678903", sum: 0, wantErr: &ValidationError{}},
+		{name: "negative sum", rules: rules, number: "12345678903", sum: -5, wantErr: &ValidationError{}},
+		{name: "negative order value", rules: rules, number: "12345678903", sum: 20, orderValue: -1, wantErr: &ValidationError{}},
+		{name: "bad check digit", rules: rules, number: "12345678901", sum: 20, wantErr: ErrorInvalidOrderNumber},
+		{name: "not digits", rules: rules, number: "1234abc", sum: 20, wantErr: ErrorInvalidOrderNumber},
+		{name: "empty number", rules: rules, number: "", sum: 20, wantErr: ErrorInvalidOrderNumber},
+		{name: "merchant without a checksum", rules: rules, merchant: "plain", number: "12345678901", sum: 20},
+		{name: "merchant format still applies", rules: rules, merchant: "plain", number: "1234abc", sum: 20, wantErr: ErrorInvalidOrderNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &servicetest.Store{}, Limits{Redemption: tt.rules})
+
+			value, err := s.checkRedemption(s.numbers.For(tt.merchant), tt.number, tt.sum, tt.orderValue)
+			if !matchError(err, tt.wantErr) {
+				t.Fatalf("checkRedemption error = %v, want %v", err, tt.wantErr)
+			}
+			if (value == nil) != (tt.wantValue == nil) || value != nil && *value != *tt.wantValue {
+				t.Fatalf("checkRedemption value = %v, want %v", deref(value), deref(tt.wantValue))
+			}
+		})
+	}
+}
+
+func TestSubmitOrder(t *testing.T) {
+	const userID = 7
+	owners := map[string]int{"2377225624": userID, "79927398713": userID + 1}
+	tests := []struct {
+		name        string
+		merchant    string
+		number      string
+		wantCreated bool
+		wantErr     error
+	}{
+		{name: "new order", number: "12345678903", wantCreated: true},
+		{name: "surrounding spaces", number: " 12345678903 ", wantCreated: true},
+		{name: "own order again", number: "2377225624"},
+		{name: "another user's order", number: "79927398713", wantErr: ErrorOrderConflict},
+		{name: "bad check digit", number: "12345678901", wantErr: ErrorInvalidOrderNumber},
+		{name: "not digits", number: "1234abc", wantErr: ErrorInvalidOrderNumber},
+		{name: "empty number", number: "", wantErr: ErrorInvalidOrderNumber},
+		{name: "merchant without a checksum", merchant: "plain", number: "12345678901", wantCreated: true},
+		{name: "unknown merchant", merchant: "unknown", number: "12345678903", wantErr: ErrorUnknownMerchant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var added string
+			store := &servicetest.Store{
+				GetMerchantByCodeFunc: func(_ context.Context, code string) (*models.Merchant, error) {
+					if code == "unknown" {
+						return nil, database.ErrorMerchantNotFound
+					}
+					return &models.Merchant{ID: 1, Code: code}, nil
+				},
+				OrderExistsFunc: func(_ context.Context, _ int, number string) (bool, int, error) {
+					owner, ok := owners[number]
+					return ok, owner, nil
+				},
+				AddOrderFunc: func(_ context.Context, _, _ int, number string) error {
+					added = number
+					return nil
+				},
+			}
+			s := newTestService(t, store, Limits{})
+
+			created, err := s.SubmitOrder(context.Background(), userID, tt.merchant, tt.number)
+			if !matchError(err, tt.wantErr) || created != tt.wantCreated {
+				t.Fatalf("SubmitOrder = %v, %v, want %v, %v", created, err, tt.wantCreated, tt.wantErr)
+			}
+			if created && added != strings.TrimSpace(tt.number) {
+				t.Errorf("stored order %q, want the trimmed number", added)
+			}
+		})
+	}
+}
+
+func TestSubmitOrders(t *testing.T) {
+	const userID = 7
+	store := &servicetest.Store{
+		AddOrdersFunc: func(ctx context.Context, gotUserID, merchantID int, numbers []string) (map[string]bool, map[string]int, error) {
+			if want := []string{"12345678903", "2377225624", "79927398713", "4561261212345467"}; !slices.Equal(numbers, want) {
+				t.Errorf("AddOrders got %v, want the valid numbers once each %v", numbers, want)
+			}
+			return map[string]bool{"12345678903": true, "4561261212345467": true},
+				map[string]int{"12345678903": userID, "2377225624": userID, "79927398713": userID + 1, "4561261212345467": userID}, nil
+		},
+	}
+	s := newTestService(t, store, Limits{OrderBatch: 10})
+
+	results, err := s.SubmitOrders(context.Background(), userID, "", []string{
+		" 12345678903 ", "2377225624", "79927398713", "12345678901", "12345678903", "4561261212345467", "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.OrderResult{
+		{Number: "12345678903", Result: models.OrderResultAccepted},
+		{Number: "2377225624", Result: models.OrderResultDuplicateOwn},
+		{Number: "79927398713", Result: models.OrderResultDuplicateOther},
+		{Number: "12345678901", Result: models.OrderResultInvalid, Reason: ordernumber.ErrorChecksum.Error()},
+		{Number: "12345678903", Result: models.OrderResultDuplicateOwn},
+		{Number: "4561261212345467", Result: models.OrderResultAccepted},
+		{Number: "", Result: models.OrderResultInvalid, Reason: ordernumber.ErrorEmpty.Error()},
+	}
+	if !slices.Equal(results, want) {
+		t.Fatalf("SubmitOrders =\n%v\nwant\n%v", results, want)
+	}
+}
+
+func TestSubmitOrdersBatchSize(t *testing.T) {
+	s := newTestService(t, &servicetest.Store{}, Limits{OrderBatch: 2})
+
+	for _, numbers := range [][]string{nil, {"12345678903", "2377225624", "79927398713"}} {
+		_, err := s.SubmitOrders(context.Background(), 1, "", numbers)
+		var validation *ValidationError
+		if !errors.As(err, &validation) {
+			t.Errorf("SubmitOrders of %d numbers = %v, want a validation error", len(numbers), err)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      Redemption
+		orderValue float32
+		wantLimit  float32
+		wantErr    bool
+	}{
+		{name: "percent of the order", rules: Redemption{MaxPercent: 30}, orderValue: 200, wantLimit: 60},
+		{name: "capped by the maximum", rules: Redemption{MaxPercent: 50, Max: 40}, orderValue: 200, wantLimit: 40},
+		{name: "maximum above the share", rules: Redemption{MaxPercent: 10, Max: 40}, orderValue: 200, wantLimit: 20},
+		{name: "rounded down to cents", rules: Redemption{MaxPercent: 33}, orderValue: 10.01, wantLimit: 3.30},
+		{name: "whole order", rules: Redemption{MaxPercent: 100}, orderValue: 99.99, wantLimit: 99.99},
+		{name: "no percent cap", orderValue: 80, wantLimit: 80},
+		{name: "no percent cap with a maximum", rules: Redemption{Max: 50}, orderValue: 80, wantLimit: 50},
+		{name: "zero order value", rules: Redemption{MaxPercent: 30}, orderValue: 0, wantErr: true},
+		{name: "negative order value", rules: Redemption{MaxPercent: 30}, orderValue: -10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rules.Min = 5
+			tt.rules.QuoteTTL = time.Minute
+			var gotLimit, gotMin float32
+			var gotTTL time.Duration
+			store := &servicetest.Store{
+				CreateQuoteFunc: func(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error) {
+					gotLimit, gotMin, gotTTL = maxAmount, minAmount, ttl
+					return &models.Quote{Redeemable: maxAmount, OrderValue: orderValue}, nil
+				},
+			}
+			s := newTestService(t, store, Limits{Redemption: tt.rules})
+
+			_, err := s.Quote(context.Background(), 1, "", tt.orderValue)
+			if tt.wantErr {
+				var validation *ValidationError
+				if !errors.As(err, &validation) {
+					t.Fatalf("Quote = %v, want a validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("quoted limit = %v, want %v", gotLimit, tt.wantLimit)
+			}
+			if gotMin != tt.rules.Min || gotTTL != tt.rules.QuoteTTL {
+				t.Errorf("quote min, ttl = %v, %v, want %v, %v", gotMin, gotTTL, tt.rules.Min, tt.rules.QuoteTTL)
+			}
+		})
+	}
+}
+
+func TestWithdrawRules(t *testing.T) {
+	for _, once := range []bool{true, false} {
+		var called, gotOnce bool
+		redeemed := false
+		store := &servicetest.Store{
+			WithdrawBalanceFunc: func(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error {
+				called, gotOnce = true, oncePerOrder
+				if redeemed {
+					return database.ErrorOrderRedeemed
+				}
+				return nil
+			},
+		}
+		s := newTestService(t, store, Limits{Redemption: Redemption{Min: 10, Max: 100, MaxPercent: 50, OncePerOrder: once}})
+		ctx := context.Background()
+
+		for _, req := range []models.WithdrawRequest{
+			{OrderNumber: "12345678903", Sum: 5},
+			{OrderNumber: "12345678903", Sum: 150},
+			{OrderNumber: "12345678903", Sum: 60, OrderValue: 100},
+		} {
+			if err := s.Withdraw(ctx, 1, req); err == nil || called {
+				t.Errorf("Withdraw %+v = %v, store called %v, want a rejection before the store", req, err, called)
+			}
+		}
+
+		if err := s.Withdraw(ctx, 1, models.WithdrawRequest{OrderNumber: "12345678903", Sum: 50, OrderValue: 100}); err != nil {
+			t.Fatal(err)
+		}
+		if gotOnce != once {
+			t.Errorf("store got oncePerOrder %v, want %v", gotOnce, once)
+		}
+
+		redeemed = true
+		err := s.Withdraw(ctx, 1, models.WithdrawRequest{OrderNumber: "12345678903", Sum: 50})
+		if !errors.Is(err, ErrorOrderRedeemed) {
+			t.Errorf("Withdraw of a redeemed order = %v, want %v", err, ErrorOrderRedeemed)
+		}
+	}
+}
+
+func TestWithdrawMerchant(t *testing.T) {
+	merchants := map[string]int{database.DefaultMerchant: 1, "plain": 2}
+	var gotMerchant int
+	store := &servicetest.Store{
+		GetMerchantByCodeFunc: func(ctx context.Context, code string) (*models.Merchant, error) {
+			id, ok := merchants[code]
+			if !ok {
+				return nil, database.ErrorMerchantNotFound
+			}
+			return &models.Merchant{ID: id, Code: code}, nil
+		},
+		WithdrawBalanceFunc: func(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error {
+			gotMerchant = merchantID
+			return nil
+		},
+		CreateQuoteFunc: func(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error) {
+			gotMerchant = merchantID
+			return &models.Quote{}, nil
+		},
+	}
+	s := newTestService(t, store, Limits{Redemption: Redemption{MaxPercent: 100}})
+	ctx := context.Background()
+
+	tests := []struct {
+		merchant     string
+		number       string
+		wantMerchant int
+		wantErr      error
+	}{
+		{merchant: "", number: "12345678903", wantMerchant: 1},
+		{merchant: "plain", number: "12345678901", wantMerchant: 2},
+		{merchant: "", number: "12345678901", wantErr: ErrorInvalidOrderNumber},
+		{merchant: "unknown", number: "12345678903", wantErr: ErrorUnknownMerchant},
+	}
+	for _, tt := range tests {
+		gotMerchant = 0
+		err := s.Withdraw(ctx, 1, models.WithdrawRequest{Merchant: tt.merchant, OrderNumber: tt.number, Sum: 10})
+		if !matchError(err, tt.wantErr) || gotMerchant != tt.wantMerchant {
+			t.Errorf("Withdraw for %q order %s = %v with merchant %d, want %v with merchant %d",
+				tt.merchant, tt.number, err, gotMerchant, tt.wantErr, tt.wantMerchant)
+		}
+
+		if tt.wantErr == ErrorInvalidOrderNumber {
+			continue
+		}
+		gotMerchant = 0
+		_, err = s.Quote(ctx, 1, tt.merchant, 100)
+		if !matchError(err, tt.wantErr) || gotMerchant != tt.wantMerchant {
+			t.Errorf("Quote for %q = %v with merchant %d, want %v with merchant %d",
+				tt.merchant, err, gotMerchant, tt.wantErr, tt.wantMerchant)
+		}
+	}
+}
+
+func TestRegisterSignupIP(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		var got string
+		var gotReject bool
+		store := &servicetest.Store{
+			CreateUserFunc: func(_ context.Context, user *models.User, rejectSameIP bool) (int, error) {
+				got, gotReject = user.SignupIP, rejectSameIP
+				return 1, nil
+			},
+		}
+		s := newTestService(t, store, Limits{RejectSameIPReferrals: reject})
+		if _, err := s.Register(context.Background(), "alice", "secret", "", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if reject {
+			want = "192.0.2.1"
+		}
+		if got != want || gotReject != reject {
+			t.Errorf("with rejection %v stored address %q and rejection %v, want %q", reject, got, gotReject, want)
+		}
+	}
+}
+
+func TestPruneSignupIPs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var before time.Time
+	store := &servicetest.Store{
+		ForgetSignupIPsFunc: func(_ context.Context, cutoff time.Time) (int64, error) {
+			before = cutoff
+			cancel()
+			return 1, nil
+		},
+	}
+	s := newTestService(t, store, Limits{SignupIPRetention: 24 * time.Hour})
+
+	start := time.Now()
+	s.PruneSignupIPs(ctx)
+	if want := start.Add(-24 * time.Hour); before.Before(want.Add(-time.Second)) || before.After(time.Now().Add(-24*time.Hour)) {
+		t.Errorf("ForgetSignupIPs before %v, want about %v", before, want)
+	}
+}
+
+func TestReleaseExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	store := &servicetest.Store{
+		ExpireHoldsFunc: func(context.Context) (int, int, error) {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			return 1, 2, nil
+		},
+	}
+	s := newTestService(t, store, Limits{})
+
+	done := make(chan struct{})
+	go func() {
+		s.ReleaseExpired(ctx, time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReleaseExpired did not stop after the context was cancelled")
+	}
+	if calls != 3 {
+		t.Errorf("ExpireHolds called %d times, want 3", calls)
+	}
+}
+
+// matchError reports whether err is want, or a *ValidationError when want is one.
+func matchError(err, want error) bool {
+	if _, ok := want.(*ValidationError); ok {
+		var validation *ValidationError
+		return errors.As(err, &validation)
+	}
+	if want == nil {
+		return err == nil
+	}
+	return errors.Is(err, want)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func deref(v *float32) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,249 @@
+// Package servicetest provides an in-memory stand-in for the service store.
+package servicetest
+
+import (
+	"context"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+)
+
+// Store implements service.Store with overridable funcs. An unset func succeeds
+// with empty results, merchants exist for any code and reservations echo their input.
+type Store struct {
+	CreateUserFunc        func(ctx context.Context, user *models.User, rejectSameIP bool) (int, error)
+	GetUserByLoginFunc    func(ctx context.Context, login string) (*models.User, error)
+	GetUserReferralsFunc  func(ctx context.Context, userID int) (*models.ReferralsResponse, error)
+	ForgetSignupIPsFunc   func(ctx context.Context, before time.Time) (int64, error)
+	CreateMerchantFunc    func(ctx context.Context, req *models.MerchantRequest) (*models.Merchant, error)
+	GetMerchantByCodeFunc func(ctx context.Context, code string) (*models.Merchant, error)
+	GetMerchantsFunc      func(ctx context.Context) ([]models.Merchant, error)
+	OrderExistsFunc       func(ctx context.Context, merchantID int, orderNumber string) (bool, int, error)
+	AddOrderFunc          func(ctx context.Context, userID, merchantID int, orderNumber string) error
+	AddOrdersFunc         func(ctx context.Context, userID, merchantID int, orderNumbers []string) (map[string]bool, map[string]int, error)
+	GetOrdersByUserIDFunc func(ctx context.Context, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error)
+	GetUserBalanceFunc    func(ctx context.Context, userID int) (*models.Balance, error)
+	WithdrawBalanceFunc   func(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error
+	ReserveWithdrawalFunc func(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error)
+	ConfirmWithdrawalFunc func(ctx context.Context, userID, id int) (*models.Withdrawal, error)
+	CancelWithdrawalFunc  func(ctx context.Context, userID, id int) (*models.Withdrawal, error)
+	GetWithdrawalsFunc    func(ctx context.Context, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error)
+	CreateQuoteFunc       func(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error)
+	ExpireHoldsFunc       func(ctx context.Context) (int, int, error)
+	TransferBalanceFunc   func(ctx context.Context, fromUserID int, toLogin string, amount, dailyLimit float32) error
+	GetUserTransfersFunc  func(ctx context.Context, userID int) ([]models.Transfer, error)
+	GetEventsAfterFunc    func(ctx context.Context, userID int, afterID int64, limit int) ([]models.Event, error)
+	GetBalanceAtFunc      func(ctx context.Context, userID int, t time.Time) (float32, error)
+	StreamStatementFunc   func(ctx context.Context, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error)
+	CreateCampaignFunc    func(ctx context.Context, req *models.CampaignRequest) (*models.Campaign, error)
+	GetCampaignFunc       func(ctx context.Context, id int) (*models.Campaign, error)
+	SetCampaignStatusFunc func(ctx context.Context, id int, status string) (*models.Campaign, error)
+	GetOrderFactsFunc     func(ctx context.Context, from, to time.Time) ([]models.OrderFacts, error)
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *models.User, rejectSameIP bool) (int, error) {
+	if s.CreateUserFunc == nil {
+		return 1, nil
+	}
+	return s.CreateUserFunc(ctx, user, rejectSameIP)
+}
+
+func (s *Store) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
+	if s.GetUserByLoginFunc == nil {
+		return nil, nil
+	}
+	return s.GetUserByLoginFunc(ctx, login)
+}
+
+func (s *Store) GetUserReferrals(ctx context.Context, userID int) (*models.ReferralsResponse, error) {
+	if s.GetUserReferralsFunc == nil {
+		return &models.ReferralsResponse{Invited: []models.Referral{}}, nil
+	}
+	return s.GetUserReferralsFunc(ctx, userID)
+}
+
+func (s *Store) ForgetSignupIPs(ctx context.Context, before time.Time) (int64, error) {
+	if s.ForgetSignupIPsFunc == nil {
+		return 0, nil
+	}
+	return s.ForgetSignupIPsFunc(ctx, before)
+}
+
+func (s *Store) CreateMerchant(ctx context.Context, req *models.MerchantRequest) (*models.Merchant, error) {
+	if s.CreateMerchantFunc == nil {
+		return &models.Merchant{ID: 1, Code: req.Code, Name: req.Name, AccrualAddress: req.AccrualAddress,
+			LogoURL: req.LogoURL, Color: req.Color, CreatedAt: time.Now()}, nil
+	}
+	return s.CreateMerchantFunc(ctx, req)
+}
+
+func (s *Store) GetMerchantByCode(ctx context.Context, code string) (*models.Merchant, error) {
+	if s.GetMerchantByCodeFunc == nil {
+		return &models.Merchant{ID: 1, Code: code, Name: code, CreatedAt: time.Now()}, nil
+	}
+	return s.GetMerchantByCodeFunc(ctx, code)
+}
+
+func (s *Store) GetMerchants(ctx context.Context) ([]models.Merchant, error) {
+	if s.GetMerchantsFunc == nil {
+		return []models.Merchant{}, nil
+	}
+	return s.GetMerchantsFunc(ctx)
+}
+
+func (s *Store) OrderExists(ctx context.Context, merchantID int, orderNumber string) (bool, int, error) {
+	if s.OrderExistsFunc == nil {
+		return false, 0, nil
+	}
+	return s.OrderExistsFunc(ctx, merchantID, orderNumber)
+}
+
+func (s *Store) AddOrder(ctx context.Context, userID, merchantID int, orderNumber string) error {
+	if s.AddOrderFunc == nil {
+		return nil
+	}
+	return s.AddOrderFunc(ctx, userID, merchantID, orderNumber)
+}
+
+func (s *Store) AddOrders(ctx context.Context, userID, merchantID int, orderNumbers []string) (map[string]bool, map[string]int, error) {
+	if s.AddOrdersFunc == nil {
+		accepted := make(map[string]bool)
+		owners := make(map[string]int)
+		for _, n := range orderNumbers {
+			accepted[n] = true
+			owners[n] = userID
+		}
+		return accepted, owners, nil
+	}
+	return s.AddOrdersFunc(ctx, userID, merchantID, orderNumbers)
+}
+
+func (s *Store) GetOrdersByUserID(ctx context.Context, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+	if s.GetOrdersByUserIDFunc == nil {
+		return nil, nil, nil
+	}
+	return s.GetOrdersByUserIDFunc(ctx, userID, params)
+}
+
+func (s *Store) GetUserBalance(ctx context.Context, userID int) (*models.Balance, error) {
+	if s.GetUserBalanceFunc == nil {
+		return &models.Balance{}, nil
+	}
+	return s.GetUserBalanceFunc(ctx, userID)
+}
+
+func (s *Store) WithdrawBalance(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error {
+	if s.WithdrawBalanceFunc == nil {
+		return nil
+	}
+	return s.WithdrawBalanceFunc(ctx, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, quote)
+}
+
+func (s *Store) ReserveWithdrawal(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error) {
+	if s.ReserveWithdrawalFunc == nil {
+		expiresAt := time.Now().Add(hold)
+		return &models.Withdrawal{ID: 1, OrderNumber: orderNumber, Sum: amount, OrderValue: orderValue,
+			Status: models.WithdrawalStatusPending, ExpiresAt: &expiresAt, ProcessedAt: time.Now()}, nil
+	}
+	return s.ReserveWithdrawalFunc(ctx, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, hold, quote)
+}
+
+func (s *Store) ConfirmWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	if s.ConfirmWithdrawalFunc == nil {
+		return &models.Withdrawal{ID: id, Status: models.WithdrawalStatusConfirmed, ProcessedAt: time.Now()}, nil
+	}
+	return s.ConfirmWithdrawalFunc(ctx, userID, id)
+}
+
+func (s *Store) CancelWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	if s.CancelWithdrawalFunc == nil {
+		return &models.Withdrawal{ID: id, Status: models.WithdrawalStatusCancelled, ProcessedAt: time.Now()}, nil
+	}
+	return s.CancelWithdrawalFunc(ctx, userID, id)
+}
+
+func (s *Store) GetUserWithdrawals(ctx context.Context, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+	if s.GetWithdrawalsFunc == nil {
+		return nil, nil, nil
+	}
+	return s.GetWithdrawalsFunc(ctx, userID, params)
+}
+
+func (s *Store) CreateQuote(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error) {
+	if s.CreateQuoteFunc == nil {
+		expiresAt := time.Now().Add(ttl)
+		return &models.Quote{Token: "quote", Redeemable: maxAmount, OrderValue: orderValue, ExpiresAt: &expiresAt}, nil
+	}
+	return s.CreateQuoteFunc(ctx, userID, merchantID, orderValue, maxAmount, minAmount, ttl)
+}
+
+func (s *Store) ExpireHolds(ctx context.Context) (int, int, error) {
+	if s.ExpireHoldsFunc == nil {
+		return 0, 0, nil
+	}
+	return s.ExpireHoldsFunc(ctx)
+}
+
+func (s *Store) TransferBalance(ctx context.Context, fromUserID int, toLogin string, amount, dailyLimit float32) error {
+	if s.TransferBalanceFunc == nil {
+		return nil
+	}
+	return s.TransferBalanceFunc(ctx, fromUserID, toLogin, amount, dailyLimit)
+}
+
+func (s *Store) GetUserTransfers(ctx context.Context, userID int) ([]models.Transfer, error) {
+	if s.GetUserTransfersFunc == nil {
+		return nil, nil
+	}
+	return s.GetUserTransfersFunc(ctx, userID)
+}
+
+func (s *Store) GetEventsAfter(ctx context.Context, userID int, afterID int64, limit int) ([]models.Event, error) {
+	if s.GetEventsAfterFunc == nil {
+		return nil, nil
+	}
+	return s.GetEventsAfterFunc(ctx, userID, afterID, limit)
+}
+
+func (s *Store) GetBalanceAt(ctx context.Context, userID int, t time.Time) (float32, error) {
+	if s.GetBalanceAtFunc == nil {
+		return 0, nil
+	}
+	return s.GetBalanceAtFunc(ctx, userID, t)
+}
+
+func (s *Store) StreamStatement(ctx context.Context, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error) {
+	if s.StreamStatementFunc == nil {
+		return opening, nil
+	}
+	return s.StreamStatementFunc(ctx, userID, from, to, opening, fn)
+}
+
+func (s *Store) CreateCampaign(ctx context.Context, req *models.CampaignRequest) (*models.Campaign, error) {
+	if s.CreateCampaignFunc == nil {
+		return &models.Campaign{ID: 1}, nil
+	}
+	return s.CreateCampaignFunc(ctx, req)
+}
+
+func (s *Store) GetCampaign(ctx context.Context, id int) (*models.Campaign, error) {
+	if s.GetCampaignFunc == nil {
+		return &models.Campaign{ID: id}, nil
+	}
+	return s.GetCampaignFunc(ctx, id)
+}
+
+func (s *Store) SetCampaignStatus(ctx context.Context, id int, status string) (*models.Campaign, error) {
+	if s.SetCampaignStatusFunc == nil {
+		return &models.Campaign{ID: id, Status: status}, nil
+	}
+	return s.SetCampaignStatusFunc(ctx, id, status)
+}
+
+func (s *Store) GetProcessedOrderFacts(ctx context.Context, from, to time.Time) ([]models.OrderFacts, error) {
+	if s.GetOrderFactsFunc == nil {
+		return nil, nil
+	}
+	return s.GetOrderFactsFunc(ctx, from, to)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/database"
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+	"github.com/KirillZiborov/go-loyalty-program/internal/pagination"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Store is the storage the service runs on, it returns the database package errors.
+type Store interface {
+	CreateUser(ctx context.Context, user *models.User, rejectSameIP bool) (int, error)
+	GetUserByLogin(ctx context.Context, login string) (*models.User, error)
+	GetUserReferrals(ctx context.Context, userID int) (*models.ReferralsResponse, error)
+	ForgetSignupIPs(ctx context.Context, before time.Time) (int64, error)
+
+	CreateMerchant(ctx context.Context, req *models.MerchantRequest) (*models.Merchant, error)
+	GetMerchantByCode(ctx context.Context, code string) (*models.Merchant, error)
+	GetMerchants(ctx context.Context) ([]models.Merchant, error)
+
+	OrderExists(ctx context.Context, merchantID int, orderNumber string) (bool, int, error)
+	AddOrder(ctx context.Context, userID, merchantID int, orderNumber string) error
+	AddOrders(ctx context.Context, userID, merchantID int, orderNumbers []string) (map[string]bool, map[string]int, error)
+	GetOrdersByUserID(ctx context.Context, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error)
+
+	GetUserBalance(ctx context.Context, userID int) (*models.Balance, error)
+	WithdrawBalance(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error
+	ReserveWithdrawal(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error)
+	ConfirmWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error)
+	CancelWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error)
+	GetUserWithdrawals(ctx context.Context, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error)
+	CreateQuote(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error)
+	ExpireHolds(ctx context.Context) (withdrawals, quotes int, err error)
+
+	TransferBalance(ctx context.Context, fromUserID int, toLogin string, amount, dailyLimit float32) error
+	GetUserTransfers(ctx context.Context, userID int) ([]models.Transfer, error)
+
+	GetEventsAfter(ctx context.Context, userID int, afterID int64, limit int) ([]models.Event, error)
+	GetBalanceAt(ctx context.Context, userID int, t time.Time) (float32, error)
+	StreamStatement(ctx context.Context, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error)
+
+	CreateCampaign(ctx context.Context, req *models.CampaignRequest) (*models.Campaign, error)
+	GetCampaign(ctx context.Context, id int) (*models.Campaign, error)
+	SetCampaignStatus(ctx context.Context, id int, status string) (*models.Campaign, error)
+	GetProcessedOrderFacts(ctx context.Context, from, to time.Time) ([]models.OrderFacts, error)
+}
+
+// PostgresStore returns the Store kept in db.
+func PostgresStore(db *pgxpool.Pool) Store {
+	return postgresStore{db: db}
+}
+
+type postgresStore struct {
+	db *pgxpool.Pool
+}
+
+func (p postgresStore) CreateUser(ctx context.Context, user *models.User, rejectSameIP bool) (int, error) {
+	return database.CreateUser(ctx, p.db, user, rejectSameIP)
+}
+
+func (p postgresStore) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
+	return database.GetUserByLogin(ctx, p.db, login)
+}
+
+func (p postgresStore) ForgetSignupIPs(ctx context.Context, before time.Time) (int64, error) {
+	return database.ForgetSignupIPs(ctx, p.db, before)
+}
+
+func (p postgresStore) GetUserReferrals(ctx context.Context, userID int) (*models.ReferralsResponse, error) {
+	return database.GetUserReferrals(ctx, p.db, userID)
+}
+
+func (p postgresStore) CreateMerchant(ctx context.Context, req *models.MerchantRequest) (*models.Merchant, error) {
+	return database.CreateMerchant(ctx, p.db, req)
+}
+
+func (p postgresStore) GetMerchantByCode(ctx context.Context, code string) (*models.Merchant, error) {
+	return database.GetMerchantByCode(ctx, p.db, code)
+}
+
+func (p postgresStore) GetMerchants(ctx context.Context) ([]models.Merchant, error) {
+	return database.GetMerchants(ctx, p.db)
+}
+
+func (p postgresStore) OrderExists(ctx context.Context, merchantID int, orderNumber string) (bool, int, error) {
+	return database.OrderExists(ctx, p.db, merchantID, orderNumber)
+}
+
+func (p postgresStore) AddOrder(ctx context.Context, userID, merchantID int, orderNumber string) error {
+	return database.AddOrder(ctx, p.db, userID, merchantID, orderNumber)
+}
+
+func (p postgresStore) AddOrders(ctx context.Context, userID, merchantID int, orderNumbers []string) (map[string]bool, map[string]int, error) {
+	return database.AddOrders(ctx, p.db, userID, merchantID, orderNumbers)
+}
+
+func (p postgresStore) GetOrdersByUserID(ctx context.Context, userID int, params *pagination.Params) ([]models.Order, *pagination.Cursor, error) {
+	return database.GetOrdersByUserID(ctx, p.db, userID, params)
+}
+
+func (p postgresStore) GetUserBalance(ctx context.Context, userID int) (*models.Balance, error) {
+	return database.GetUserBalance(ctx, p.db, userID)
+}
+
+func (p postgresStore) WithdrawBalance(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, quote string) error {
+	return database.WithdrawBalance(ctx, p.db, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, quote)
+}
+
+func (p postgresStore) ReserveWithdrawal(ctx context.Context, userID, merchantID int, amount float32, orderNumber string, orderValue *float32, oncePerOrder bool, hold time.Duration, quote string) (*models.Withdrawal, error) {
+	return database.ReserveWithdrawal(ctx, p.db, userID, merchantID, amount, orderNumber, orderValue, oncePerOrder, hold, quote)
+}
+
+func (p postgresStore) ConfirmWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	return database.ConfirmWithdrawal(ctx, p.db, userID, id)
+}
+
+func (p postgresStore) CancelWithdrawal(ctx context.Context, userID, id int) (*models.Withdrawal, error) {
+	return database.CancelWithdrawal(ctx, p.db, userID, id)
+}
+
+func (p postgresStore) GetUserWithdrawals(ctx context.Context, userID int, params *pagination.Params) ([]models.Withdrawal, *pagination.Cursor, error) {
+	return database.GetUserWithdrawals(ctx, p.db, userID, params)
+}
+
+func (p postgresStore) CreateQuote(ctx context.Context, userID, merchantID int, orderValue, maxAmount, minAmount float32, ttl time.Duration) (*models.Quote, error) {
+	return database.CreateQuote(ctx, p.db, userID, merchantID, orderValue, maxAmount, minAmount, ttl)
+}
+
+func (p postgresStore) ExpireHolds(ctx context.Context) (int, int, error) {
+	return database.ExpireHolds(ctx, p.db)
+}
+
+func (p postgresStore) TransferBalance(ctx context.Context, fromUserID int, toLogin string, amount, dailyLimit float32) error {
+	return database.TransferBalance(ctx, p.db, fromUserID, toLogin, amount, dailyLimit)
+}
+
+func (p postgresStore) GetUserTransfers(ctx context.Context, userID int) ([]models.Transfer, error) {
+	return database.GetUserTransfers(ctx, p.db, userID)
+}
+
+func (p postgresStore) GetEventsAfter(ctx context.Context, userID int, afterID int64, limit int) ([]models.Event, error) {
+	return database.GetEventsAfter(ctx, p.db, userID, afterID, limit)
+}
+
+func (p postgresStore) GetBalanceAt(ctx context.Context, userID int, t time.Time) (float32, error) {
+	return database.GetBalanceAt(ctx, p.db, userID, t)
+}
+
+func (p postgresStore) StreamStatement(ctx context.Context, userID int, from, to time.Time, opening float32, fn func(models.StatementEntry) error) (float32, error) {
+	return database.StreamStatement(ctx, p.db, userID, from, to, opening, fn)
+}
+
+func (p postgresStore) CreateCampaign(ctx context.Context, req *models.CampaignRequest) (*models.Campaign, error) {
+	return database.CreateCampaign(ctx, p.db, req)
+}
+
+func (p postgresStore) GetCampaign(ctx context.Context, id int) (*models.Campaign, error) {
+	return database.GetCampaign(ctx, p.db, id)
+}
+
+func (p postgresStore) SetCampaignStatus(ctx context.Context, id int, status string) (*models.Campaign, error) {
+	return database.SetCampaignStatus(ctx, p.db, id, status)
+}
+
+func (p postgresStore) GetProcessedOrderFacts(ctx context.Context, from, to time.Time) ([]models.OrderFacts, error) {
+	return database.GetProcessedOrderFacts(ctx, p.db, from, to)
+}
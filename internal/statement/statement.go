@@ -0,0 +1,135 @@
+package statement
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/KirillZiborov/go-loyalty-program/internal/models"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatText  = "txt"
+)
+
+var ErrorUnknownFormat = errors.New("unknown statement format")
+
+// Writer renders a statement: the opening balance, entries in chronological order and the closing balance.
+type Writer interface {
+	Opening(at time.Time, balance float32) error
+	Entry(e models.StatementEntry) error
+	Closing(at time.Time, balance float32) error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case FormatText:
+		return &textWriter{w: w}, nil
+	default:
+		return nil, ErrorUnknownFormat
+	}
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+func amount(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', 2, 32)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Opening(at time.Time, balance float32) error {
+	c.w.Write([]string{"date", "type", "reference", "amount", "balance"})
+	return c.write([]string{at.Format(time.RFC3339), "OPENING_BALANCE", "", "", amount(balance)})
+}
+
+func (c *csvWriter) Entry(e models.StatementEntry) error {
+	return c.write([]string{e.Date.Format(time.RFC3339), e.Type, e.Reference, amount(e.Amount), amount(e.Balance)})
+}
+
+func (c *csvWriter) Closing(at time.Time, balance float32) error {
+	return c.write([]string{at.Format(time.RFC3339), "CLOSING_BALANCE", "", "", amount(balance)})
+}
+
+func (c *csvWriter) write(record []string) error {
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlLine struct {
+	Date      string   `json:"date"`
+	Type      string   `json:"type"`
+	Reference string   `json:"reference,omitempty"`
+	Amount    *float32 `json:"amount,omitempty"`
+	Balance   float32  `json:"balance"`
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Opening(at time.Time, balance float32) error {
+	return j.enc.Encode(jsonlLine{Date: at.Format(time.RFC3339), Type: "OPENING_BALANCE", Balance: balance})
+}
+
+func (j *jsonlWriter) Entry(e models.StatementEntry) error {
+	return j.enc.Encode(jsonlLine{
+		Date:      e.Date.Format(time.RFC3339),
+		Type:      e.Type,
+		Reference: e.Reference,
+		Amount:    &e.Amount,
+		Balance:   e.Balance,
+	})
+}
+
+func (j *jsonlWriter) Closing(at time.Time, balance float32) error {
+	return j.enc.Encode(jsonlLine{Date: at.Format(time.RFC3339), Type: "CLOSING_BALANCE", Balance: balance})
+}
+
+const textRow = "%-25s %-16s %-20s %12s %12s\n"
+
+type textWriter struct {
+	w io.Writer
+}
+
+func (t *textWriter) Opening(at time.Time, balance float32) error {
+	if _, err := fmt.Fprintf(t.w, textRow, "DATE", "TYPE", "REFERENCE", "AMOUNT", "BALANCE"); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, textRow, at.Format(time.RFC3339), "OPENING_BALANCE", "", "", amount(balance))
+	return err
+}
+
+func (t *textWriter) Entry(e models.StatementEntry) error {
+	_, err := fmt.Fprintf(t.w, textRow, e.Date.Format(time.RFC3339), e.Type, e.Reference, amount(e.Amount), amount(e.Balance))
+	return err
+}
+
+func (t *textWriter) Closing(at time.Time, balance float32) error {
+	_, err := fmt.Fprintf(t.w, textRow, at.Format(time.RFC3339), "CLOSING_BALANCE", "", "", amount(balance))
+	return err
+}
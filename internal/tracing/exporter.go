@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives finished spans. Implementations must be safe for concurrent use.
+type Exporter interface {
+	Export(span *SpanData) error
+	Shutdown() error
+}
+
+// JSONExporter writes every span as a JSON line.
+type JSONExporter struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewStdoutExporter writes spans to standard output.
+func NewStdoutExporter() *JSONExporter {
+	return &JSONExporter{w: bufio.NewWriter(os.Stdout)}
+}
+
+// NewFileExporter appends spans to the file at path.
+func NewFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONExporter{w: bufio.NewWriter(f), closer: f}, nil
+}
+
+func (e *JSONExporter) Export(span *SpanData) error {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(line)
+	e.w.WriteByte('\n')
+	return e.w.Flush()
+}
+
+func (e *JSONExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
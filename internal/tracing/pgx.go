@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PgxTracer creates spans for pool acquires and every query executed through pgx.
+type PgxTracer struct{}
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, span := Start(ctx, "pgx.query")
+	span.SetAttribute("db.statement", strings.Join(strings.Fields(data.SQL), " "))
+	return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.SetAttribute("db.rows_affected", data.CommandTag.RowsAffected())
+	span.RecordError(data.Err)
+	span.End()
+}
+
+func (PgxTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	ctx, _ = Start(ctx, "pgxpool.acquire")
+	return ctx
+}
+
+func (PgxTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.RecordError(data.Err)
+	span.End()
+}
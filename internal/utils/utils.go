@@ -1,25 +1,28 @@
 package utils
 
-import "unicode"
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
 
-func CheckLuhn(number string) bool {
-	var sum int
-	double := false
+const referralAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
 
-	for i := len(number) - 1; i >= 0; i-- {
-		n := rune(number[i])
-		if !unicode.IsDigit(n) {
-			return false
-		}
-		digit := int(n - '0')
-		if double {
-			digit = digit * 2
-			if digit > 9 {
-				digit = digit - 9
-			}
-		}
-		sum += digit
-		double = !double
+func GenerateReferralCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return sum%10 == 0
+	for i := range b {
+		b[i] = referralAlphabet[int(b[i])%len(referralAlphabet)]
+	}
+	return string(b), nil
+}
+
+// GenerateToken returns a random 128-bit token in hex.
+func GenerateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }